@@ -29,8 +29,15 @@ package main
 
 import (
 	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -41,9 +48,9 @@ type GameResult struct {
 }
 
 type Results struct {
-	Red   int
-	Green int
-	Blue  int
+	Red   int `json:"red"`
+	Green int `json:"green"`
+	Blue  int `json:"blue"`
 }
 
 func parseGameResult(line string) (GameResult, error) {
@@ -120,9 +127,96 @@ func parseDrawing(drawing string) (int, int, int, error) {
 // For each game, call the function to determine if the game is possible or not.
 // Print the game ID and whether the game is possible or not.
 // Finally, print the sum of the game IDs of all possible games.
+//
+// With -where, only the IDs of the games matching the query expression are
+// printed instead (see parseQuery for the expression language).  With -agg,
+// the count, sum, minimum and maximum of an expression over the matching games
+// are printed as well.
+//
+// With -export csv or -export json, the parsed games are written to stdout
+// instead (only those matching -where, if it's given; -agg can't be combined
+// with -export), and with -import the input file is read as a previous JSON
+// export rather than as a game log.
+//
+// With -optimize K, the smallest single bag that makes at least K percent of
+// the games possible is printed instead (see optimizeBag).
+//
+// With -generate, no input file is read; instead a random game log for a known
+// bag is written to stdout (see generateGames).
 
 func main() {
-	file, err := os.Open(os.Args[1])
+	where := flag.String("where", "", "only print games matching this query expression")
+	agg := flag.String("agg", "", "print count, sum, min and max of this expression over matching games")
+	export := flag.String("export", "", "write the parsed games to stdout as csv or json")
+	importJSON := flag.Bool("import", false, "read the input file as a JSON export instead of a game log")
+	optimize := flag.Float64("optimize", 0, "find the smallest bag making at least this percentage of games possible")
+	weights := flag.String("weights", "1,1,1", "cost per red,green,blue cube for -optimize")
+	generate := flag.Bool("generate", false, "write a random game log to stdout instead of reading one")
+	bag := flag.String("bag", "12,13,14", "red,green,blue cubes in the bag for -generate")
+	numGames := flag.Int("games", 100, "number of games for -generate")
+	numDraws := flag.Int("draws", 3, "number of draws per game for -generate")
+	seed := flag.Int64("seed", 1, "random seed for -generate")
+	flag.Parse()
+
+	if *generate {
+		if err := runGenerate(*bag, *numGames, *numDraws, *seed); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: solution [-where <expr>] [-agg <expr>] [-export csv|json] [-import] [-optimize <percent> [-weights r,g,b]] <input file>")
+		fmt.Println("       solution -generate [-bag r,g,b] [-games n] [-draws n] [-seed n]")
+		os.Exit(1)
+	}
+
+	if *optimize != 0 {
+		if err := runOptimize(flag.Arg(0), *importJSON, *optimize, *weights); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *export != "" && *agg != "" {
+		fmt.Println("-agg can't be combined with -export")
+		os.Exit(1)
+	}
+
+	if *export != "" {
+		if err := runExport(flag.Arg(0), *importJSON, *export, *where); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *where != "" || *agg != "" {
+		if err := runQuery(flag.Arg(0), *importJSON, *where, *agg); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *importJSON {
+		games, err := importGameResults(flag.Arg(0))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		gameSum := 0
+		for _, gameResult := range games {
+			gameSum += printGameResult(gameResult)
+		}
+		fmt.Println("Sum of powers of minimum cubes:", gameSum)
+		return
+	}
+
+	file, err := os.Open(flag.Arg(0))
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -139,12 +233,7 @@ func main() {
 			continue
 		}
 
-		possible := isPossible(gameResult)
-		fmt.Printf("Game %d: %t\n", gameResult.GameID, possible)
-
-		minimumCubes := determineMinimumCubes(gameResult)
-		powerOfMinimumCubes := minimumCubes.Red * minimumCubes.Green * minimumCubes.Blue
-		gameSum += powerOfMinimumCubes
+		gameSum += printGameResult(gameResult)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -155,6 +244,59 @@ func main() {
 	fmt.Println("Sum of powers of minimum cubes:", gameSum)
 }
 
+// Print whether a single game is possible and its minimum cubes, returning
+// the power of the minimum cubes.
+func printGameResult(gameResult GameResult) int {
+	possible := isPossible(gameResult)
+	fmt.Printf("Game %d: %t\n", gameResult.GameID, possible)
+
+	minimumCubes := determineMinimumCubes(gameResult)
+	fmt.Println("Minimum Cubes:", minimumCubes) // Print minimumCubes for debugging/verbose purposes
+
+	return minimumCubes.Red * minimumCubes.Green * minimumCubes.Blue
+}
+
+// Load every game from the input file, either as a game log or, if
+// importJSON is set, as a JSON export.
+func loadGameResults(filename string, importJSON bool) ([]GameResult, error) {
+	if importJSON {
+		return importGameResults(filename)
+	}
+	return readGameResults(filename)
+}
+
+// Read every game from the input file into a slice, stopping at the first
+// line that fails to parse.
+func readGameResults(filename string) ([]GameResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var games []GameResult
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		gameResult, err := parseGameResult(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		games = append(games, gameResult)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return games, nil
+}
+
 // Write a function to determine if a game is possible or not.  The function
 // should take a GameResult as input and return a bool indicating if the game
 // is possible or not.
@@ -195,7 +337,877 @@ func determineMinimumCubes(gameResult GameResult) Results {
 		}
 	}
 
-	fmt.Println("Minimum Cubes:", minimumCubes) // Print minimumCubes for debugging/verbose purposes
-
 	return minimumCubes
 }
+
+// The query language used by -where and -agg is a small expression language
+// evaluated against a single GameResult.  All values are integers; comparisons
+// and the logical operators produce 1 (true) or 0 (false), and any non-zero
+// value counts as true.  For example:
+//
+//	max(blue) > 10 && draws >= 3
+//	power > 1000 || !possible
+//
+// Identifiers:
+//
+//	id                 the game ID
+//	draws              the number of draws in the game
+//	red, green, blue   the minimum number of cubes of that color (see determineMinimumCubes)
+//	power              the power of the minimum cubes (red * green * blue)
+//	possible           1 if the game is possible with the 12/13/14 bag, otherwise 0
+//
+// Functions, taking a color (red, green, blue, or total for all colors):
+//
+//	max(color), min(color), sum(color)   over the draws of the game
+//
+// Operators, from lowest to highest precedence:
+//
+//	||   &&   == != < <= > >=   + -   * / %   unary - !
+
+// A queryNode is a parsed query expression that can be evaluated against a game.
+type queryNode interface {
+	eval(gameResult GameResult) (int, error)
+}
+
+type queryNumber int
+
+type queryIdent string
+
+type queryFunc struct {
+	name  string
+	color string
+}
+
+type queryUnary struct {
+	op      string
+	operand queryNode
+}
+
+type queryBinary struct {
+	op          string
+	left, right queryNode
+}
+
+var queryIdents = map[string]bool{
+	"id": true, "draws": true, "red": true, "green": true, "blue": true, "power": true, "possible": true,
+}
+
+var queryColors = map[string]bool{
+	"red": true, "green": true, "blue": true, "total": true,
+}
+
+func (n queryNumber) eval(gameResult GameResult) (int, error) {
+	return int(n), nil
+}
+
+func (n queryIdent) eval(gameResult GameResult) (int, error) {
+	switch n {
+	case "id":
+		return gameResult.GameID, nil
+	case "draws":
+		return len(gameResult.Results), nil
+	case "possible":
+		return boolToInt(isPossible(gameResult)), nil
+	}
+
+	minimumCubes := determineMinimumCubes(gameResult)
+	switch n {
+	case "red":
+		return minimumCubes.Red, nil
+	case "green":
+		return minimumCubes.Green, nil
+	case "blue":
+		return minimumCubes.Blue, nil
+	case "power":
+		return minimumCubes.Red * minimumCubes.Green * minimumCubes.Blue, nil
+	}
+
+	return 0, fmt.Errorf("unknown identifier: %s", string(n))
+}
+
+func (n queryFunc) eval(gameResult GameResult) (int, error) {
+	value := 0
+	for i, result := range gameResult.Results {
+		count := colorCount(result, n.color)
+		switch {
+		case n.name == "sum":
+			value += count
+		case i == 0:
+			value = count
+		case n.name == "max" && count > value:
+			value = count
+		case n.name == "min" && count < value:
+			value = count
+		}
+	}
+	return value, nil
+}
+
+func (n queryUnary) eval(gameResult GameResult) (int, error) {
+	value, err := n.operand.eval(gameResult)
+	if err != nil {
+		return 0, err
+	}
+
+	if n.op == "!" {
+		return boolToInt(value == 0), nil
+	}
+	return -value, nil
+}
+
+func (n queryBinary) eval(gameResult GameResult) (int, error) {
+	left, err := n.left.eval(gameResult)
+	if err != nil {
+		return 0, err
+	}
+
+	// Short-circuit the logical operators
+	if n.op == "&&" && left == 0 {
+		return 0, nil
+	}
+	if n.op == "||" && left != 0 {
+		return 1, nil
+	}
+
+	right, err := n.right.eval(gameResult)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		return boolToInt(right != 0), nil
+	case "==":
+		return boolToInt(left == right), nil
+	case "!=":
+		return boolToInt(left != right), nil
+	case "<":
+		return boolToInt(left < right), nil
+	case "<=":
+		return boolToInt(left <= right), nil
+	case ">":
+		return boolToInt(left > right), nil
+	case ">=":
+		return boolToInt(left >= right), nil
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero in game %d", gameResult.GameID)
+		}
+		if n.op == "/" {
+			return left / right, nil
+		}
+		return left % right, nil
+	}
+
+	return 0, fmt.Errorf("unknown operator: %s", n.op)
+}
+
+// Return the number of cubes of the given color (or all colors, for "total")
+// in a single draw.
+func colorCount(result Results, color string) int {
+	switch color {
+	case "red":
+		return result.Red
+	case "green":
+		return result.Green
+	case "blue":
+		return result.Blue
+	}
+	return result.Red + result.Green + result.Blue
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Split a query expression into tokens: numbers, identifiers, parentheses and
+// operators.
+func tokenizeQuery(query string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, query[i:j])
+			i = j
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_':
+			j := i
+			for j < len(query) && (query[j] >= 'a' && query[j] <= 'z' || query[j] >= 'A' && query[j] <= 'Z' || query[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, strings.ToLower(query[i:j]))
+			i = j
+		default:
+			// Two-character operators take priority over their one-character prefixes
+			if i+1 < len(query) {
+				switch op := query[i : i+2]; op {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					tokens = append(tokens, op)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("()<>+-*/%!", rune(c)) {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+
+	return tokens, nil
+}
+
+// A recursive-descent parser for query expressions, one method per
+// precedence level.
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+// Parse a query expression into a tree of queryNodes.
+func parseQuery(query string) (queryNode, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	p := &queryParser{tokens: tokens}
+	node, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in query", p.tokens[p.pos])
+	}
+
+	return node, nil
+}
+
+// Binary operators grouped by precedence, lowest first.
+var queryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *queryParser) expect(token string) error {
+	if p.peek() != token {
+		if p.peek() == "" {
+			return fmt.Errorf("expected %q at end of query", token)
+		}
+		return fmt.Errorf("expected %q but found %q", token, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *queryParser) parseBinary(level int) (queryNode, error) {
+	if level == len(queryPrecedence) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		found := false
+		for _, candidate := range queryPrecedence[level] {
+			if op == candidate {
+				found = true
+			}
+		}
+		if !found {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = queryBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if op := p.peek(); op == "-" || op == "!" {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return queryUnary{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	token := p.peek()
+	if token == "" {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	p.pos++
+
+	if token == "(" {
+		node, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	}
+
+	if number, err := strconv.Atoi(token); err == nil {
+		return queryNumber(number), nil
+	}
+
+	if token == "max" || token == "min" || token == "sum" {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		color := p.peek()
+		if !queryColors[color] {
+			return nil, fmt.Errorf("invalid color for %s(): %q", token, color)
+		}
+		p.pos++
+		return queryFunc{name: token, color: color}, p.expect(")")
+	}
+
+	if queryIdents[token] {
+		return queryIdent(token), nil
+	}
+
+	return nil, fmt.Errorf("unknown identifier: %s", token)
+}
+
+// Run the -where / -agg query over every game in the input file, printing the
+// IDs of the matching games and, if an aggregate expression is given, its
+// count, sum, minimum and maximum over those games.
+func runQuery(filename string, importJSON bool, where string, agg string) error {
+	var filter, aggregate queryNode
+	var err error
+
+	if where != "" {
+		if filter, err = parseQuery(where); err != nil {
+			return fmt.Errorf("invalid -where: %w", err)
+		}
+	}
+	if agg != "" {
+		if aggregate, err = parseQuery(agg); err != nil {
+			return fmt.Errorf("invalid -agg: %w", err)
+		}
+	}
+
+	games, err := loadGameResults(filename, importJSON)
+	if err != nil {
+		return err
+	}
+
+	var matchingIDs []string
+	count, sum, min, max := 0, 0, 0, 0
+
+	for _, gameResult := range games {
+		if filter != nil {
+			matches, err := filter.eval(gameResult)
+			if err != nil {
+				return err
+			}
+			if matches == 0 {
+				continue
+			}
+		}
+		matchingIDs = append(matchingIDs, strconv.Itoa(gameResult.GameID))
+
+		if aggregate != nil {
+			value, err := aggregate.eval(gameResult)
+			if err != nil {
+				return err
+			}
+			if count == 0 || value < min {
+				min = value
+			}
+			if count == 0 || value > max {
+				max = value
+			}
+			sum += value
+			count++
+		}
+	}
+
+	fmt.Println("Matching games:", strings.Join(matchingIDs, " "))
+
+	if aggregate != nil {
+		fmt.Println("Count:", count)
+		fmt.Println("Sum:", sum)
+		if count > 0 {
+			fmt.Println("Min:", min)
+			fmt.Println("Max:", max)
+		}
+	}
+
+	return nil
+}
+
+// A GameExport is the JSON form of a single game: its draws, as parsed by
+// parseGameResult, along with the computed minimum cubes and whether the game
+// is possible.  The computed fields are ignored on import.
+type GameExport struct {
+	GameID       int       `json:"id"`
+	Draws        []Results `json:"draws"`
+	MinimumCubes Results   `json:"minimum"`
+	Power        int       `json:"power"`
+	Possible     bool      `json:"possible"`
+}
+
+// Header row for the CSV export.  There is one row per color per draw; the
+// minimum cubes and possible flag are repeated on every row of a game.
+var csvExportHeader = []string{
+	"game", "draw", "color", "count", "min_red", "min_green", "min_blue", "power", "possible",
+}
+
+func newGameExport(gameResult GameResult) GameExport {
+	minimumCubes := determineMinimumCubes(gameResult)
+	return GameExport{
+		GameID:       gameResult.GameID,
+		Draws:        gameResult.Results,
+		MinimumCubes: minimumCubes,
+		Power:        minimumCubes.Red * minimumCubes.Green * minimumCubes.Blue,
+		Possible:     isPossible(gameResult),
+	}
+}
+
+// Write every game in the input file (or, if where is given, every game
+// matching it) to stdout in the given format (csv or json).
+func runExport(filename string, importJSON bool, format string, where string) error {
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("invalid export format: %s (expected csv or json)", format)
+	}
+
+	var filter queryNode
+	if where != "" {
+		var err error
+		if filter, err = parseQuery(where); err != nil {
+			return fmt.Errorf("invalid -where: %w", err)
+		}
+	}
+
+	games, err := loadGameResults(filename, importJSON)
+	if err != nil {
+		return err
+	}
+
+	if filter != nil {
+		var matching []GameResult
+		for _, gameResult := range games {
+			matches, err := filter.eval(gameResult)
+			if err != nil {
+				return err
+			}
+			if matches != 0 {
+				matching = append(matching, gameResult)
+			}
+		}
+		games = matching
+	}
+
+	if format == "json" {
+		return exportJSON(os.Stdout, games)
+	}
+	return exportCSV(os.Stdout, games)
+}
+
+func exportJSON(w io.Writer, games []GameResult) error {
+	exports := make([]GameExport, len(games))
+	for i, gameResult := range games {
+		exports[i] = newGameExport(gameResult)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(exports)
+}
+
+// Write one CSV row per color drawn in each draw.  Colors that weren't drawn
+// are left out, just as they are in the game log.
+func exportCSV(w io.Writer, games []GameResult) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvExportHeader); err != nil {
+		return err
+	}
+
+	for _, gameResult := range games {
+		export := newGameExport(gameResult)
+		perGame := []string{
+			strconv.Itoa(export.MinimumCubes.Red),
+			strconv.Itoa(export.MinimumCubes.Green),
+			strconv.Itoa(export.MinimumCubes.Blue),
+			strconv.Itoa(export.Power),
+			strconv.FormatBool(export.Possible),
+		}
+
+		for i, result := range gameResult.Results {
+			for _, color := range []string{"red", "green", "blue"} {
+				count := colorCount(result, color)
+				if count == 0 {
+					continue
+				}
+				row := []string{strconv.Itoa(gameResult.GameID), strconv.Itoa(i + 1), color, strconv.Itoa(count)}
+				if err := writer.Write(append(row, perGame...)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Read a JSON export (as written by -export json) back into GameResults.
+func importGameResults(filename string) ([]GameResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var exports []GameExport
+	if err := json.NewDecoder(file).Decode(&exports); err != nil {
+		return nil, fmt.Errorf("invalid JSON export %s: %w", filename, err)
+	}
+
+	games := make([]GameResult, len(exports))
+	for i, export := range exports {
+		for _, result := range export.Draws {
+			if result.Red < 0 || result.Green < 0 || result.Blue < 0 {
+				return nil, fmt.Errorf("invalid negative count in game %d", export.GameID)
+			}
+		}
+		games[i] = GameResult{GameID: export.GameID, Results: export.Draws}
+	}
+
+	return games, nil
+}
+
+// A BagChoice is a candidate bag found by optimizeBag, along with its cost and
+// the games it makes possible.
+type BagChoice struct {
+	Bag      Results
+	Cost     int
+	Admitted []int
+}
+
+// Parse a comma-separated list of red, green and blue values, as given to the
+// -weights and -bag flags.
+func parseColorCounts(name string, counts string) (Results, error) {
+	parts := strings.Split(counts, ",")
+	if len(parts) != 3 {
+		return Results{}, fmt.Errorf("invalid -%s: %s (expected red,green,blue)", name, counts)
+	}
+
+	var values [3]int
+	for i, part := range parts {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || value < 0 {
+			return Results{}, fmt.Errorf("invalid -%s value: %s", name, part)
+		}
+		values[i] = value
+	}
+
+	return Results{Red: values[0], Green: values[1], Blue: values[2]}, nil
+}
+
+// Return the cost of a bag under the given per-color weights.
+func bagCost(bag Results, weights Results) int {
+	return bag.Red*weights.Red + bag.Green*weights.Green + bag.Blue*weights.Blue
+}
+
+// Return the IDs of the games that are possible with the given bag.
+func admittedGames(minimums []Results, games []GameResult, bag Results) []int {
+	var admitted []int
+	for i, minimumCubes := range minimums {
+		if minimumCubes.Red <= bag.Red && minimumCubes.Green <= bag.Green && minimumCubes.Blue <= bag.Blue {
+			admitted = append(admitted, games[i].GameID)
+		}
+	}
+	return admitted
+}
+
+// Return the distinct values in a slice, in ascending order.
+func distinctSorted(values []int) []int {
+	seen := make(map[int]bool)
+	var distinct []int
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			distinct = append(distinct, value)
+		}
+	}
+	sort.Ints(distinct)
+	return distinct
+}
+
+// Find, for every k from 1 to the number of games, the cheapest bag that makes
+// at least k games possible.  Element k-1 of the returned slice is the bag for
+// k games, so the whole trade-off curve comes from a single search.
+//
+// A bag only ever needs as many cubes of a color as some game's minimum for
+// that color, so the red and green counts are searched over the distinct
+// per-game minimums.  For each red/green pair, the games that fit are walked
+// in order of their blue minimum (sorted once, up front), and the k-th
+// smallest blue minimum is the blue count needed to admit k of them.  That's
+// O(reds * greens * games), however many cubes the games need.
+func optimizeBag(games []GameResult, weights Results) []BagChoice {
+	minimums := make([]Results, len(games))
+	var reds, greens []int
+	for i, gameResult := range games {
+		minimums[i] = determineMinimumCubes(gameResult)
+		reds = append(reds, minimums[i].Red)
+		greens = append(greens, minimums[i].Green)
+	}
+	reds = distinctSorted(reds)
+	greens = distinctSorted(greens)
+
+	byBlue := append([]Results(nil), minimums...)
+	sort.SliceStable(byBlue, func(i, j int) bool { return byBlue[i].Blue < byBlue[j].Blue })
+
+	best := make([]BagChoice, len(games))
+	found := make([]bool, len(games))
+
+	for _, red := range reds {
+		for _, green := range greens {
+			// Walk the games that fit in ascending order of blue minimum;
+			// the k-th of them can be admitted (along with the k-1 before
+			// it) with that many blue cubes.
+			k := 0
+			for _, minimumCubes := range byBlue {
+				if minimumCubes.Red > red || minimumCubes.Green > green {
+					continue
+				}
+
+				bag := Results{Red: red, Green: green, Blue: minimumCubes.Blue}
+				cost := bagCost(bag, weights)
+				if !found[k] || betterBag(bag, cost, best[k]) {
+					best[k] = BagChoice{Bag: bag, Cost: cost}
+					found[k] = true
+				}
+				k++
+			}
+		}
+	}
+
+	// A bag admitting more games is also a valid answer for fewer games, so
+	// carry cheaper bags backward along the curve.
+	for k := len(best) - 2; k >= 0; k-- {
+		if betterBag(best[k+1].Bag, best[k+1].Cost, best[k]) {
+			best[k] = best[k+1]
+		}
+	}
+
+	for k := range best {
+		best[k].Admitted = admittedGames(minimums, games, best[k].Bag)
+	}
+
+	return best
+}
+
+// Report whether a bag with the given cost beats the current choice: a lower
+// cost wins, then fewer cubes in total, then fewer red and then green cubes.
+func betterBag(bag Results, cost int, current BagChoice) bool {
+	if cost != current.Cost {
+		return cost < current.Cost
+	}
+	total, currentTotal := bag.Red+bag.Green+bag.Blue, current.Bag.Red+current.Bag.Green+current.Bag.Blue
+	if total != currentTotal {
+		return total < currentTotal
+	}
+	if bag.Red != current.Bag.Red {
+		return bag.Red < current.Bag.Red
+	}
+	return bag.Green < current.Bag.Green
+}
+
+// Run the -optimize search over the games in the input file, printing the
+// cheapest bag that makes at least the given percentage of the games possible,
+// the games it admits, and the trade-off curve of cost against games admitted.
+func runOptimize(filename string, importJSON bool, percent float64, weightsFlag string) error {
+	if percent <= 0 || percent > 100 {
+		return fmt.Errorf("invalid -optimize percentage: %g (expected 0 < K <= 100)", percent)
+	}
+
+	weights, err := parseColorCounts("weights", weightsFlag)
+	if err != nil {
+		return err
+	}
+
+	games, err := loadGameResults(filename, importJSON)
+	if err != nil {
+		return err
+	}
+	if len(games) == 0 {
+		return fmt.Errorf("no games in %s", filename)
+	}
+
+	curve := optimizeBag(games, weights)
+
+	// The number of games needed, rounding up so that we never fall short
+	// of the requested percentage
+	needed := int(math.Ceil(percent * float64(len(games)) / 100))
+	if needed < 1 {
+		needed = 1
+	}
+	choice := curve[needed-1]
+
+	admitted := make([]string, len(choice.Admitted))
+	for i, gameID := range choice.Admitted {
+		admitted[i] = strconv.Itoa(gameID)
+	}
+
+	fmt.Printf("Bag for at least %g%% of %d games: %d red, %d green, %d blue (cost %d)\n",
+		percent, len(games), choice.Bag.Red, choice.Bag.Green, choice.Bag.Blue, choice.Cost)
+	fmt.Printf("Admitted games (%d): %s\n", len(choice.Admitted), strings.Join(admitted, " "))
+
+	fmt.Println()
+	fmt.Println("Games  Percent  Red  Green  Blue  Cost")
+	for k, point := range curve {
+		// Only print the points where the curve changes
+		if k > 0 && point.Bag == curve[k-1].Bag {
+			continue
+		}
+		fmt.Printf("%5d  %6.1f%%  %3d  %5d  %4d  %4d\n",
+			len(point.Admitted), 100*float64(len(point.Admitted))/float64(len(games)),
+			point.Bag.Red, point.Bag.Green, point.Bag.Blue, point.Cost)
+	}
+
+	return nil
+}
+
+// Generate a random game log for a bag with the given number of cubes of each
+// color.  For each draw, a handful of between one cube and the whole bag is
+// taken without replacement, counted, and put back before the next draw.  The
+// same seed always produces the same games.
+func generateGames(bag Results, numGames int, numDraws int, seed int64) []GameResult {
+	rng := rand.New(rand.NewSource(seed))
+
+	var cubes []string
+	for _, color := range []string{"red", "green", "blue"} {
+		for i := 0; i < colorCount(bag, color); i++ {
+			cubes = append(cubes, color)
+		}
+	}
+
+	games := make([]GameResult, numGames)
+	for g := range games {
+		games[g] = GameResult{GameID: g + 1, Results: make([]Results, numDraws)}
+		for d := range games[g].Results {
+			handful := 1 + rng.Intn(len(cubes))
+			rng.Shuffle(len(cubes), func(i, j int) { cubes[i], cubes[j] = cubes[j], cubes[i] })
+
+			var result Results
+			for _, color := range cubes[:handful] {
+				switch color {
+				case "red":
+					result.Red++
+				case "green":
+					result.Green++
+				case "blue":
+					result.Blue++
+				}
+			}
+			games[g].Results[d] = result
+		}
+	}
+
+	return games
+}
+
+// Format a game as a line of the game log, e.g. "Game 1: 3 blue, 4 red; 2 green".
+// Colors not drawn are left out, and the order of the colors within each draw
+// is shuffled using rng, since parseGameResult accepts them in any order.
+func formatGameResult(gameResult GameResult, rng *rand.Rand) string {
+	draws := make([]string, len(gameResult.Results))
+	for i, result := range gameResult.Results {
+		var colors []string
+		for _, color := range []string{"red", "green", "blue"} {
+			if count := colorCount(result, color); count > 0 {
+				colors = append(colors, fmt.Sprintf("%d %s", count, color))
+			}
+		}
+		rng.Shuffle(len(colors), func(i, j int) { colors[i], colors[j] = colors[j], colors[i] })
+		draws[i] = strings.Join(colors, ", ")
+	}
+
+	return fmt.Sprintf("Game %d: %s", gameResult.GameID, strings.Join(draws, "; "))
+}
+
+// Write a generated game log to stdout, and the expected answers for it (the
+// sum of the IDs of the games possible with the 12/13/14 bag, and the sum of
+// the powers of the minimum cubes) to stderr so they don't end up in the log.
+func runGenerate(bagFlag string, numGames int, numDraws int, seed int64) error {
+	bag, err := parseColorCounts("bag", bagFlag)
+	if err != nil {
+		return err
+	}
+	if bag.Red+bag.Green+bag.Blue == 0 {
+		return fmt.Errorf("invalid -bag: %s (the bag must contain at least one cube)", bagFlag)
+	}
+	if numGames < 1 || numDraws < 1 {
+		return fmt.Errorf("invalid -games or -draws: both must be at least 1")
+	}
+
+	games := generateGames(bag, numGames, numDraws, seed)
+
+	// A separate generator for the color order, so that the games themselves
+	// only depend on the seed and not on how they're formatted
+	rng := rand.New(rand.NewSource(seed))
+	writer := bufio.NewWriter(os.Stdout)
+	possibleSum, powerSum := 0, 0
+	for _, gameResult := range games {
+		fmt.Fprintln(writer, formatGameResult(gameResult, rng))
+
+		if isPossible(gameResult) {
+			possibleSum += gameResult.GameID
+		}
+		minimumCubes := determineMinimumCubes(gameResult)
+		powerSum += minimumCubes.Red * minimumCubes.Green * minimumCubes.Blue
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "Sum of IDs of possible games:", possibleSum)
+	fmt.Fprintln(os.Stderr, "Sum of powers of minimum cubes:", powerSum)
+
+	return nil
+}
@@ -0,0 +1,7 @@
+module github.com/misterdorm/aoc-2023/day03
+
+go 1.21
+
+require github.com/misterdorm/aoc-2023/grid v0.0.0
+
+replace github.com/misterdorm/aoc-2023/grid => ../grid
@@ -19,223 +19,1563 @@
 // ......755.
 // ...$.*....
 // .664.598..
+//
+// Which characters are symbols, and which symbols are gears, can be changed
+// with a rules file given with -rules (see Rules).  Which cells count as
+// adjacent can be changed with -adjacency: 8 (the default, including
+// diagonals), 4 (no diagonals), or chebyshev:k or manhattan:k for every cell
+// within distance k.
+//
+// With -stream, the schematic is analyzed as it's read, keeping only the rows
+// within reach of the current one in memory (see StreamAnalyzer), so huge
+// schematics can be processed.  With -check, it's analyzed both ways and the
+// results are compared.
+//
+// Every row must be the same length, and contain only printable ASCII
+// characters.  -validate lists every problem with the input (including
+// Windows line endings, which are otherwise ignored), and -pad pads short rows
+// with the first blank character instead of failing.
+//
+// With -generate, no input file is read; instead a random schematic is written
+// to stdout, and its expected sums to stderr (see GenerateSchematic).  -fuzz n
+// generates n schematics and checks both analyzers against the expected sums.
+//
+// With -render ansi or -render html, the schematic itself is written to stdout
+// instead, with part numbers, other numbers, symbols and gears highlighted.
+// With -graph dot or -graph json, the graph of which part numbers are attached
+// to which symbols is written to stdout instead (see WriteGraph).  With
+// -stats, a summary of the symbols and numbers found is printed instead, to
+// sanity-check an input before trusting the sums.
+//
+// The grid itself (Grid, Point, Neighborhood) is the shared grid package at
+// the top of the repository, which go.mod points at with a replace directive,
+// so other days can use it too.
 
 package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
 	"log"
+	"math/rand"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+
+	"github.com/misterdorm/aoc-2023/grid"
 )
 
-var checkedLocations map[int]bool
-var width int
-var ratioSum int
+// A Schematic is an engine schematic diagram, read from an io.Reader.  All of
+// the analysis is done by its methods, so a Schematic can be analyzed any
+// number of times, and separate schematics can be analyzed concurrently.
+type Schematic struct {
+	grid *grid.Grid[byte]
+
+	// The rules for which characters are symbols, and which symbols are
+	// gears.  NewSchematic sets this to DefaultRules().
+	Rules Rules
+
+	// Which cells around a symbol are adjacent to it.  NewSchematic sets this
+	// to the 8 surrounding cells.
+	Adjacency grid.Neighborhood
+}
+
+// A Number is a run of digits in the schematic.  Symbols holds the indexes
+// (into Analysis.Symbols) of the symbols it's adjacent to; a number adjacent
+// to at least one symbol is a part number.
+type Number struct {
+	Pos     grid.Point
+	Length  int
+	Value   int
+	Symbols []int
+}
+
+// IsPart reports whether the number is a part number.
+func (n Number) IsPart() bool {
+	return len(n.Symbols) > 0
+}
+
+// A Symbol is any character in the schematic that the rules consider a
+// symbol; by default, anything other than a period/dot or a digit.  Numbers
+// holds the indexes (into Analysis.Numbers) of the numbers adjacent to it, in
+// position order.
+type Symbol struct {
+	Pos     grid.Point
+	Char    byte
+	Numbers []int
+}
+
+// A Gear is a symbol whose adjacent numbers match one of the aggregations in
+// the rules.  With the default rules, that's an asterisk (*) adjacent to
+// exactly two part numbers, and its Value (the gear ratio) is the product of
+// the two numbers.  Unlike Symbol.Numbers, Values holds the values of the
+// adjacent numbers themselves, not their indexes.
+type Gear struct {
+	Pos    grid.Point
+	Char   byte
+	Values []int
+	Value  int
+}
+
+// Rules define a schematic convention: which characters are blank, which are
+// symbols, and how the numbers around particular symbols are aggregated into
+// gears.  They can be loaded from a JSON file, for example:
+//
+//	{
+//	  "blank": ".",
+//	  "symbols": "",
+//	  "aggregations": [
+//	    {"symbol": "*", "compare": "==", "count": 2, "op": "product"},
+//	    {"symbol": "#", "compare": ">=", "count": 3, "op": "sum"}
+//	  ]
+//	}
+type Rules struct {
+	// Characters that are neither numbers nor symbols.
+	Blank string `json:"blank"`
+
+	// If not empty, only these characters are symbols, and any other
+	// non-digit characters are treated as blank.  If empty, every character
+	// that isn't a digit or blank is a symbol.
+	Symbols string `json:"symbols"`
+
+	// How the numbers around a symbol make it a gear.  The first aggregation
+	// matching a symbol is used.
+	Aggregations []Aggregation `json:"aggregations"`
+}
+
+// An Aggregation makes a symbol a gear when the number of numbers adjacent to
+// it compares (with Compare: ==, !=, <, <=, > or >=) to Count.  The gear's
+// Value is then Op (product, sum, min or max) of those numbers.
+type Aggregation struct {
+	Symbol  string `json:"symbol"`
+	Compare string `json:"compare"`
+	Count   int    `json:"count"`
+	Op      string `json:"op"`
+}
+
+// DefaultRules returns the puzzle's own rules: periods/dots are blank,
+// everything else other than digits is a symbol, and an asterisk (*) adjacent
+// to exactly two numbers is a gear whose ratio is their product.
+func DefaultRules() Rules {
+	return Rules{
+		Blank: ".",
+		Aggregations: []Aggregation{
+			{Symbol: "*", Compare: "==", Count: 2, Op: "product"},
+		},
+	}
+}
+
+// An Analysis holds every number and symbol in a schematic, with the
+// adjacency between them, and the gears.
+type Analysis struct {
+	Numbers []Number
+	Symbols []Symbol
+	Gears   []Gear
+}
 
 func main() {
+	rulesFile := flag.String("rules", "", "JSON file of symbol and gear rules (default: the puzzle's rules)")
+	render := flag.String("render", "", "write the annotated schematic to stdout as ansi or html")
+	graph := flag.String("graph", "", "write the symbol/part number graph to stdout as dot or json")
+	adjacency := flag.String("adjacency", "8", "adjacent cells: 4, 8, chebyshev:k or manhattan:k")
+	stream := flag.Bool("stream", false, "analyze the schematic as it's read, without loading it all into memory")
+	check := flag.Bool("check", false, "analyze the schematic both whole and streaming, and compare the results")
+	validate := flag.Bool("validate", false, "list every problem with the input file")
+	pad := flag.Bool("pad", false, "pad short rows with blanks instead of failing")
+	stats := flag.Bool("stats", false, "print a summary of the symbols and numbers in the schematic")
+	generate := flag.Bool("generate", false, "write a random schematic to stdout instead of reading one")
+	fuzz := flag.Int("fuzz", 0, "check the analyzers against this many random schematics")
+	var config GeneratorConfig
+	flag.IntVar(&config.Width, "width", 140, "schematic width for -generate and -fuzz")
+	flag.IntVar(&config.Height, "height", 140, "schematic height for -generate and -fuzz")
+	flag.Float64Var(&config.NumberDensity, "numbers", 0.08, "numbers per cell for -generate and -fuzz")
+	flag.Float64Var(&config.SymbolDensity, "symbols", 0.03, "symbols per cell for -generate and -fuzz")
+	flag.Float64Var(&config.GearDensity, "gears", 0.01, "gears per cell for -generate and -fuzz")
+	flag.Int64Var(&config.Seed, "seed", 1, "random seed for -generate and -fuzz")
+	flag.Parse()
+
+	neighborhood, err := grid.ParseNeighborhood(*adjacency)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rules := DefaultRules()
+	if *rulesFile != "" {
+		if rules, err = LoadRules(*rulesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *generate {
+		generated, err := GenerateSchematic(config, rules, neighborhood)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(generated.Text)
+		fmt.Fprintln(os.Stderr, "Sum of part numbers:", generated.PartNumberSum)
+		fmt.Fprintln(os.Stderr, "Sum of gear ratios:", generated.GearRatioSum)
+		return
+	}
+
+	if *fuzz > 0 {
+		if err := Fuzz(config, *fuzz, rules, neighborhood); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d random schematics checked\n", *fuzz)
+		return
+	}
+
+	if flag.NArg() != 1 {
+		log.Fatal("Usage: solution [-rules <rules.json>] [-adjacency 4|8|chebyshev:k|manhattan:k] [-pad] [-validate | -stream | -check | -stats | -render ansi|html | -graph dot|json] <input file>\n" +
+			"       solution [-rules <rules.json>] [-adjacency ...] -generate | -fuzz <n> [-width w] [-height h] [-numbers d] [-symbols d] [-gears d] [-seed s]")
+	}
+
 	// Open the file specified on the command line
-	file, err := os.Open(os.Args[1])
+	file, err := os.Open(flag.Arg(0))
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer file.Close()
 
-	// Create an array of length 3 to store the most recent three lines
-	var previousLines [3]string
+	if *validate {
+		issues, err := ValidateSchematic(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+		if len(issues) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("No problems found")
+		return
+	}
+
+	// Padding uses the first blank character
+	var padding byte
+	if *pad {
+		if rules.Blank == "" {
+			log.Fatal("-pad needs at least one blank character in the rules")
+		}
+		padding = rules.Blank[0]
+	}
+
+	if *stream {
+		partSum, ratioSum := 0, 0
+		analyzer := StreamAnalyzer{
+			Rules:      rules,
+			Adjacency:  neighborhood,
+			Pad:        *pad,
+			PartNumber: func(number Number) { partSum += number.Value },
+			Gear:       func(gear Gear) { ratioSum += gear.Value },
+		}
+		if err := analyzer.Run(file); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("Sum of part numbers:", partSum)
+		fmt.Println("Sum of gear ratios:", ratioSum)
+		return
+	}
+
+	if *check {
+		input, err := io.ReadAll(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := CompareAnalyzers(input, rules, neighborhood, padding); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Whole-grid and streaming analyses match")
+		return
+	}
+
+	var schematic *Schematic
+	if *pad {
+		schematic, err = NewPaddedSchematic(file, padding)
+	} else {
+		schematic, err = NewSchematic(file)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	schematic.Rules = rules
+	schematic.Adjacency = neighborhood
+
+	if *render != "" {
+		if err := schematic.Render(os.Stdout, *render); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *graph != "" {
+		if err := schematic.FindPartNumbers().WriteGraph(os.Stdout, *graph); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *stats {
+		if err := schematic.FindPartNumbers().WriteStats(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Add up all of the part numbers
+	sum := 0
+	for _, num := range schematic.PartNumbers() {
+		sum += num
+	}
+
+	fmt.Println("Sum of part numbers:", sum)
+	fmt.Println("Sum of gear ratios:", schematic.GearRatioSum())
+}
+
+// NewSchematic reads a schematic, one line per row, from r.  It fails with
+// the first problem found in the input (see ValidateSchematic).
+func NewSchematic(r io.Reader) (*Schematic, error) {
+	return readSchematic(r, false, 0)
+}
+
+// NewPaddedSchematic reads a schematic like NewSchematic, except that rows
+// shorter than the longest row are padded with the blank character, and empty
+// lines at the end are ignored.
+func NewPaddedSchematic(r io.Reader, blank byte) (*Schematic, error) {
+	return readSchematic(r, true, blank)
+}
+
+func readSchematic(r io.Reader, pad bool, blank byte) (*Schematic, error) {
+	var checker lineChecker
+
+	// Each line is checked as it's read, so the problems are reported as
+	// SchematicIssues, in order, before grid.Read's own checks
+	cells, err := grid.Read(r, grid.ReadOptions{
+		Line: func(line []byte) ([]byte, error) {
+			line, issues := checker.check(line)
+			return line, firstIssue(issues, pad)
+		},
+		Pad:  pad,
+		Fill: blank,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := firstIssue(checker.finish(), pad); err != nil {
+		return nil, err
+	}
+
+	return &Schematic{grid: cells, Rules: DefaultRules(), Adjacency: grid.Neighborhood{Metric: "chebyshev", Radius: 1}}, nil
+}
+
+// Kinds of problem found in a schematic.
+const (
+	issueRagged        = "ragged row"
+	issueCRLF          = "CRLF line ending"
+	issueNonASCII      = "non-ASCII byte"
+	issueControl       = "control character"
+	issueTrailingEmpty = "empty trailing line"
+)
 
-	// Keep track of the special case at the beginning of the file
-	var firstLineDone bool = false
+// A SchematicIssue is a problem with the text of a schematic, at a 1-based
+// line and column.
+type SchematicIssue struct {
+	Line    int
+	Column  int
+	Kind    string
+	Message string
+}
 
-	// Create a partNumbers map to keep track of all found part numbers
-	var partNumbers []string
+func (i SchematicIssue) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s: %s", i.Line, i.Column, i.Kind, i.Message)
+}
 
-	// Initialize the checkedLocations map
-	checkedLocations = make(map[int]bool)
-	ratioSum = 0
+// ValidateSchematic reads a schematic from r, returning every problem with
+// it: rows that aren't the same length as the first non-empty row, Windows
+// (CRLF) line endings, bytes that aren't ASCII, control characters (which
+// would otherwise be read as symbols), and empty lines at the end.
+func ValidateSchematic(r io.Reader) ([]SchematicIssue, error) {
+	var issues []SchematicIssue
+	var checker lineChecker
 
-	// Read one line from the file at a time
-	lineCount := 0
-	scanner := bufio.NewScanner(file)
+	scanner := grid.NewScanner(r)
 	for scanner.Scan() {
-		line := scanner.Text()
+		_, lineIssues := checker.check(scanner.Bytes())
+		issues = append(issues, lineIssues...)
+	}
 
-		// If the width variable is undefined or not set to a value, set it to the length of the first line (less the newline character)
-		if width == 0 {
-			width = len(line)
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return append(issues, checker.finish()...), nil
+}
+
+// Return the first issue as an error, ignoring CRLF line endings (the \r is
+// dropped), and ragged rows and trailing empty lines when padding.
+func firstIssue(issues []SchematicIssue, pad bool) error {
+	for _, issue := range issues {
+		if issue.Kind == issueCRLF || pad && (issue.Kind == issueRagged || issue.Kind == issueTrailingEmpty) {
+			continue
+		}
+		return issue
+	}
+	return nil
+}
+
+// A lineChecker checks the lines of a schematic one at a time, as they're
+// read.  Empty lines are held back until the next non-empty line, since only
+// then is it known whether they're ragged rows or trailing empty lines.
+type lineChecker struct {
+	lines        int
+	width        int
+	widthKnown   bool
+	pendingEmpty []int
+}
+
+// Check the next line (as split by grid.NewScanner), returning the line without
+// any \r ending, and any problems found with it (or with empty lines before
+// it).  Only the first non-ASCII or control character on a line is reported.
+func (lc *lineChecker) check(line []byte) ([]byte, []SchematicIssue) {
+	var issues []SchematicIssue
+	lc.lines++
+
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		issues = append(issues, SchematicIssue{lc.lines, len(line), issueCRLF, "line ends with \\r"})
+		line = line[:len(line)-1]
+	}
+
+	if len(line) == 0 {
+		lc.pendingEmpty = append(lc.pendingEmpty, lc.lines)
+		return line, issues
+	}
+
+	for c, char := range line {
+		if char >= 0x80 {
+			issues = append(issues, SchematicIssue{lc.lines, c + 1, issueNonASCII, fmt.Sprintf("byte 0x%02x", char)})
+			break
+		}
+		if char < 0x20 || char == 0x7f {
+			issues = append(issues, SchematicIssue{lc.lines, c + 1, issueControl, fmt.Sprintf("byte 0x%02x", char)})
+			break
+		}
+	}
+
+	// The width is the length of the first non-empty line
+	if !lc.widthKnown {
+		lc.width = len(line)
+		lc.widthKnown = true
+	}
+	for _, empty := range lc.pendingEmpty {
+		issues = append(issues, SchematicIssue{empty, 1, issueRagged, fmt.Sprintf("empty line, expected length %d", lc.width)})
+	}
+	lc.pendingEmpty = nil
+
+	if len(line) != lc.width {
+		issues = append(issues, SchematicIssue{lc.lines, minInt(len(line), lc.width) + 1, issueRagged,
+			fmt.Sprintf("length %d, expected %d", len(line), lc.width)})
+	}
+
+	// Report the issues in order of position
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Column < issues[j].Column
+	})
+	return line, issues
+}
+
+// Return any problems found at the end of the schematic.
+func (lc *lineChecker) finish() []SchematicIssue {
+	var issues []SchematicIssue
+	for _, empty := range lc.pendingEmpty {
+		issues = append(issues, SchematicIssue{empty, 1, issueTrailingEmpty, "empty line after the last row"})
+	}
+	lc.pendingEmpty = nil
+	return issues
+}
+
+// LoadRules reads and validates a JSON rules file.
+func LoadRules(filename string) (Rules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Rules{}, err
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return Rules{}, fmt.Errorf("invalid rules file %s: %w", filename, err)
+	}
+	if err := rules.Validate(); err != nil {
+		return Rules{}, fmt.Errorf("invalid rules file %s: %w", filename, err)
+	}
+
+	return rules, nil
+}
+
+// Validate checks that the rules are consistent: digits can't be blank or
+// symbols, no character can be both, and every aggregation names a single
+// symbol character with a known comparison and operation.
+func (r Rules) Validate() error {
+	for _, char := range []byte(r.Blank + r.Symbols) {
+		if isNumber(char) {
+			return fmt.Errorf("digit %q can't be blank or a symbol", char)
+		}
+	}
+	for _, char := range []byte(r.Symbols) {
+		if strings.IndexByte(r.Blank, char) >= 0 {
+			return fmt.Errorf("%q can't be both blank and a symbol", char)
+		}
+	}
+
+	for _, aggregation := range r.Aggregations {
+		if len(aggregation.Symbol) != 1 || !r.IsSymbol(aggregation.Symbol[0]) {
+			return fmt.Errorf("aggregation symbol %q is not a single symbol character", aggregation.Symbol)
 		}
+		switch aggregation.Compare {
+		case "==", "!=", "<", "<=", ">", ">=":
+		default:
+			return fmt.Errorf("unknown comparison %q for symbol %q", aggregation.Compare, aggregation.Symbol)
+		}
+		switch aggregation.Op {
+		case "product", "sum", "min", "max":
+		default:
+			return fmt.Errorf("unknown operation %q for symbol %q", aggregation.Op, aggregation.Symbol)
+		}
+	}
 
-		// Store the current line in the array
-		previousLines[2] = line
+	return nil
+}
 
-		// Process the previous two lines
-		if previousLines[0] != "" && previousLines[1] != "" {
-			if !firstLineDone {
-				// Special case for the first line of the file
-				// Shift previousLines array to the right, with the first element being an empty string
-				var firstLines [3]string
-				firstLines[0] = ""
-				firstLines[1] = previousLines[0]
-				firstLines[2] = previousLines[1]
-				nums := FindPartNumbers(firstLines, lineCount-2)
+// IsSymbol reports whether a character is a symbol under the rules.
+func (r Rules) IsSymbol(char byte) bool {
+	if isNumber(char) || strings.IndexByte(r.Blank, char) >= 0 {
+		return false
+	}
+	return r.Symbols == "" || strings.IndexByte(r.Symbols, char) >= 0
+}
 
-				// Merge the array returned by FindNumbersAt() into the partNumbers array
-				partNumbers = append(partNumbers, nums...)
+// Aggregate returns the gear value of a symbol adjacent to the given numbers,
+// using the first matching aggregation.  The second result is false if no
+// aggregation matches, in which case the symbol isn't a gear.  Aggregations
+// whose Symbol isn't a single character (which Validate rejects, but rules
+// built in code might not have been validated) never match.
+func (r Rules) Aggregate(char byte, numbers []int) (int, bool) {
+	for _, aggregation := range r.Aggregations {
+		if len(aggregation.Symbol) != 1 || aggregation.Symbol[0] != char || !compareCount(len(numbers), aggregation.Compare, aggregation.Count) {
+			continue
+		}
 
-				firstLineDone = true
+		value := 0
+		for i, num := range numbers {
+			switch {
+			case i == 0:
+				value = num
+			case aggregation.Op == "product":
+				value *= num
+			case aggregation.Op == "sum":
+				value += num
+			case aggregation.Op == "min" && num < value:
+				value = num
+			case aggregation.Op == "max" && num > value:
+				value = num
 			}
+		}
+		return value, true
+	}
+
+	return 0, false
+}
+
+func compareCount(count int, compare string, target int) bool {
+	switch compare {
+	case "==":
+		return count == target
+	case "!=":
+		return count != target
+	case "<":
+		return count < target
+	case "<=":
+		return count <= target
+	case ">":
+		return count > target
+	case ">=":
+		return count >= target
+	}
+	return false
+}
 
-			nums := FindPartNumbers(previousLines, lineCount-1)
-			// Merge the array returned by FindNumbersAt() into the partNumbers array
-			partNumbers = append(partNumbers, nums...)
+// PartNumbers returns every part number found in the schematic, in the order
+// they appear (top to bottom, left to right).  A number adjacent to more than
+// one symbol is only returned once.
+func (s *Schematic) PartNumbers() []int {
+	var partNumbers []int
+	for _, number := range s.FindPartNumbers().Numbers {
+		if number.IsPart() {
+			partNumbers = append(partNumbers, number.Value)
 		}
+	}
+	return partNumbers
+}
+
+// Gears returns every gear found in the schematic.
+func (s *Schematic) Gears() []Gear {
+	return s.FindPartNumbers().Gears
+}
 
-		// Shift the lines in the array
-		previousLines[0] = previousLines[1]
-		previousLines[1] = previousLines[2]
-		lineCount++
+// GearRatioSum returns the sum of the gear ratios (values) of all gears in the
+// schematic.
+func (s *Schematic) GearRatioSum() int {
+	sum := 0
+	for _, gear := range s.Gears() {
+		sum += gear.Value
 	}
+	return sum
+}
 
-	// Special case for the last line of the file
-	// Shift previousLines array to the left already done above, set last element being an empty string
-	previousLines[2] = ""
-	nums := FindPartNumbers(previousLines, lineCount)
-	// Merge the array returned by FindNumbersAt() into the partNumbers array
-	partNumbers = append(partNumbers, nums...)
+// FindPartNumbers finds every number and symbol in the schematic, and which
+// numbers are adjacent to which symbols.  A number is adjacent to a symbol if
+// any of its digits is in the symbol's neighborhood (s.Adjacency); by default
+// that's next to the symbol on the same line, above or below it, or diagonally
+// adjacent to it.  Numbers are identified by the position of their first
+// digit, so a number touching several symbols, or touching one symbol with
+// several digits, is still only one number.  Which characters are symbols, and
+// which symbols are gears, is decided by s.Rules.
+func (s *Schematic) FindPartNumbers() *Analysis {
+	analysis := &Analysis{}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	// Find the numbers, and label each digit cell with the index of its
+	// number (plus one, so that zero means "no number")
+	numberAt := grid.NewGrid[int](s.grid.Width(), s.grid.Height())
+	for _, token := range s.grid.Tokens(isNumber) {
+		value := 0
+		for _, digit := range token.Cells {
+			value = value*10 + int(digit-'0')
+		}
+
+		analysis.Numbers = append(analysis.Numbers, Number{Pos: token.Start, Length: len(token.Cells), Value: value})
+		for _, p := range token.Points() {
+			numberAt.Set(p, len(analysis.Numbers))
+		}
 	}
 
-	// Convert each key from partNumbers to an integer and add it to the sum
-	var sum int = 0
-	for _, numStr := range partNumbers {
-		num, err := strconv.Atoi(numStr)
-		if err != nil {
-			log.Fatal(err)
+	// Find the symbols, and the numbers around each of them
+	offsets := s.Adjacency.Offsets()
+	s.grid.Scan(func(p grid.Point, char byte) {
+		if !s.Rules.IsSymbol(char) {
+			return
+		}
+
+		symbol := Symbol{Pos: p, Char: char}
+		for _, n := range s.grid.Neighbors(p, offsets) {
+			if id, _ := numberAt.At(n); id > 0 && !containsInt(symbol.Numbers, id-1) {
+				symbol.Numbers = append(symbol.Numbers, id-1)
+			}
+		}
+		sort.Ints(symbol.Numbers)
+
+		for _, number := range symbol.Numbers {
+			analysis.Numbers[number].Symbols = append(analysis.Numbers[number].Symbols, len(analysis.Symbols))
+		}
+		analysis.Symbols = append(analysis.Symbols, symbol)
+
+		// If the numbers around the symbol match one of the aggregation
+		// rules, then it's a gear
+		values := make([]int, len(symbol.Numbers))
+		for i, number := range symbol.Numbers {
+			values[i] = analysis.Numbers[number].Value
+		}
+		if value, ok := s.Rules.Aggregate(char, values); ok {
+			analysis.Gears = append(analysis.Gears, Gear{Pos: p, Char: char, Values: values, Value: value})
+		}
+	})
+
+	return analysis
+}
+
+// isNumber checks if a character is a digit.
+func isNumber(char byte) bool {
+	return char >= '0' && char <= '9'
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
-		sum += num
 	}
+	return false
+}
 
-	if err != nil {
-		log.Fatal(err)
+// How a cell is highlighted when rendering the schematic.
+type cellClass int
+
+const (
+	classBlank cellClass = iota
+	classNumber
+	classPart
+	classSymbol
+	classGear
+)
+
+// Terminal escape codes for each class of cell, and the HTML class names.
+var (
+	ansiCodes = map[cellClass]string{
+		classNumber: "\x1b[31m",    // red
+		classPart:   "\x1b[32;1m",  // bold green
+		classSymbol: "\x1b[33;1m",  // bold yellow
+		classGear:   "\x1b[30;43m", // black on a yellow box
+	}
+	htmlClasses = map[cellClass]string{
+		classNumber: "number",
+		classPart:   "part",
+		classSymbol: "symbol",
+		classGear:   "gear",
 	}
-	println("Sum of part numbers:", sum)
-	println("Sum of gear ratios:", ratioSum)
-}
-
-// Find part numbers on the current line, based on context from the previous and
-// next lines.  Find any symbols (characters other that periods/dots
-// or numbers) on any of three lines.  If a symbol is found, check for numbers that
-// are immediately adjacent to it on the same line, or above or below it on the
-// previous or next line, or diagonally adjacent to it on the previous or next lines.
-// Part numbers may be single or multiple digits.  It is considered to be adjacent
-// to a symbol if any digit character of the part number is adjacent to the symbol.
-// Return an array of part numbers found on the current line.
-func FindPartNumbers(lines [3]string, lineNum int) []string {
-	var allPartNumbers []string
-	var oneSymPartNumbers []string
-
-	println("-------------")
-	println("Line 0:", lines[0])
-	println("Line 1:", lines[1])
-	println("Line 2:", lines[2])
-
-	// Look for any symbols on any line
-	for c, char := range lines[1] {
-		if !isNumber(byte(char)) && char != '.' {
-			println("Symbol at ", c, ":", string(char))
-
-			// reset oneSymPartNumbers array to empty
-			oneSymPartNumbers = nil
-
-			// Look for adjacent (horizontally) numbers on the "current" (middle) line
-			// On either side of the symbol in the line string, look for numbers
-			// that are adjacent to it in the string. The number could be one or
-			// several digits long.  If a number is found, add it to the array of
-			// part numbers.
-			if c > 0 {
-				nums := FindNumbersAt(lines[1], c-1, lineNum)
-				// Merge the array returned by FindNumbersAt() into the oneSymPartNumbers array
-				oneSymPartNumbers = append(oneSymPartNumbers, nums...)
+)
+
+const ansiReset = "\x1b[0m"
+
+const htmlHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Engine schematic</title>
+<style>
+body { background: #1e1e1e; color: #777; }
+pre { font-size: 14px; line-height: 1.3; }
+.number { color: #e06c75; }
+.part { color: #98c379; font-weight: bold; }
+.symbol { color: #e5c07b; font-weight: bold; }
+.gear { color: #1e1e1e; background: #e5c07b; outline: 1px solid #e5c07b; }
+.note { color: #61afef; }
+</style>
+</head>
+<body>
+<pre>
+`
+
+const htmlFooter = `</pre>
+</body>
+</html>
+`
+
+// Render writes the schematic to w, in the given format (ansi for a terminal,
+// or html for a standalone page), with every cell highlighted according to
+// FindPartNumbers: part numbers, numbers that aren't part numbers, symbols, and
+// gears, which are boxed.  Each row with gears on it is followed by a note of
+// the gears' columns and ratios.
+func (s *Schematic) Render(w io.Writer, format string) error {
+	if format != "ansi" && format != "html" {
+		return fmt.Errorf("invalid render format: %s (expected ansi or html)", format)
+	}
+
+	analysis := s.FindPartNumbers()
+
+	classes := grid.NewGrid[cellClass](s.grid.Width(), s.grid.Height())
+	for _, number := range analysis.Numbers {
+		class := classNumber
+		if number.IsPart() {
+			class = classPart
+		}
+		for c := 0; c < number.Length; c++ {
+			classes.Set(grid.Point{Row: number.Pos.Row, Col: number.Pos.Col + c}, class)
+		}
+	}
+	for _, symbol := range analysis.Symbols {
+		classes.Set(symbol.Pos, classSymbol)
+	}
+
+	notes := make(map[int][]string)
+	for _, gear := range analysis.Gears {
+		classes.Set(gear.Pos, classGear)
+		notes[gear.Pos.Row] = append(notes[gear.Pos.Row], fmt.Sprintf("%c@%d=%d", gear.Char, gear.Pos.Col, gear.Value))
+	}
+
+	out := bufio.NewWriter(w)
+	if format == "html" {
+		out.WriteString(htmlHeader)
+	}
+
+	for r := 0; r < s.grid.Height(); r++ {
+		row := s.grid.Row(r)
+		rowClasses := classes.Row(r)
+
+		// Write each run of cells with the same class as one span
+		for c := 0; c < len(row); {
+			end := c
+			for end < len(row) && rowClasses[end] == rowClasses[c] {
+				end++
 			}
-			if c < len(lines[1])-1 {
-				nums := FindNumbersAt(lines[1], c+1, lineNum)
-				// Merge the array returned by FindNumbersAt() into the oneSymPartNumbers array
-				oneSymPartNumbers = append(oneSymPartNumbers, nums...)
+			writeSpan(out, format, rowClasses[c], string(row[c:end]))
+			c = end
+		}
+
+		if len(notes[r]) > 0 {
+			writeSpan(out, format, classBlank, "  ")
+			if format == "html" {
+				fmt.Fprintf(out, "<span class=\"note\">%s</span>", html.EscapeString(strings.Join(notes[r], " ")))
+			} else {
+				out.WriteString(strings.Join(notes[r], " "))
 			}
+		}
+		out.WriteString("\n")
+	}
+
+	if format == "html" {
+		out.WriteString(htmlFooter)
+	}
+
+	return out.Flush()
+}
+
+// Write text highlighted as the given class of cell.
+func writeSpan(w *bufio.Writer, format string, class cellClass, text string) {
+	if format == "html" {
+		text = html.EscapeString(text)
+		if class == classBlank {
+			w.WriteString(text)
+		} else {
+			fmt.Fprintf(w, "<span class=\"%s\">%s</span>", htmlClasses[class], text)
+		}
+		return
+	}
+
+	if class == classBlank {
+		w.WriteString(text)
+	} else {
+		w.WriteString(ansiCodes[class] + text + ansiReset)
+	}
+}
 
-			// Look for adjacent (vertically and diagonally) numbers on the previous and next lines
-			for c1 := c - 1; c1 <= c+1; c1++ {
-				if c1 >= 0 && c1 < len(lines[1]) {
-					nums := FindNumbersAt(lines[0], c1, lineNum-1)
-					// Merge the array returned by FindNumbersAt() into the oneSymPartNumbers array
-					oneSymPartNumbers = append(oneSymPartNumbers, nums...)
+// A Component is a connected group of symbols and the part numbers attached
+// to them, as indexes into Analysis.Symbols and Analysis.Numbers.  A component
+// with more than one symbol is bridged: some of its numbers touch several
+// symbols.
+type Component struct {
+	Symbols []int
+	Numbers []int
+}
+
+// Bridged reports whether the component's numbers connect more than one symbol.
+func (c Component) Bridged() bool {
+	return len(c.Symbols) > 1
+}
+
+// Components returns the connected components of the graph of symbols and part
+// numbers, in the order of their first symbol.  Numbers that aren't part
+// numbers aren't in any component; symbols without any numbers are a component
+// on their own.
+func (a *Analysis) Components() []Component {
+	var components []Component
+	seen := make([]bool, len(a.Symbols))
+
+	for start := range a.Symbols {
+		if seen[start] {
+			continue
+		}
+
+		// Walk from symbol to number to symbol, breadth first
+		var component Component
+		numberSeen := make(map[int]bool)
+		queue := []int{start}
+		seen[start] = true
+		for len(queue) > 0 {
+			symbol := queue[0]
+			queue = queue[1:]
+			component.Symbols = append(component.Symbols, symbol)
 
-					nums = FindNumbersAt(lines[2], c1, lineNum+1)
-					// Merge the array returned by FindNumbersAt() into the oneSymPartNumbers array
-					oneSymPartNumbers = append(oneSymPartNumbers, nums...)
+			for _, number := range a.Symbols[symbol].Numbers {
+				if numberSeen[number] {
+					continue
+				}
+				numberSeen[number] = true
+				component.Numbers = append(component.Numbers, number)
+
+				for _, next := range a.Numbers[number].Symbols {
+					if !seen[next] {
+						seen[next] = true
+						queue = append(queue, next)
+					}
 				}
 			}
+		}
+
+		sort.Ints(component.Symbols)
+		sort.Ints(component.Numbers)
+		components = append(components, component)
+	}
+
+	return components
+}
+
+// The JSON form of the graph written by WriteGraph.
+type graphJSON struct {
+	Symbols    []graphSymbol    `json:"symbols"`
+	Numbers    []graphNumber    `json:"numbers"`
+	Edges      []graphEdge      `json:"edges"`
+	Components []graphComponent `json:"components"`
+}
+
+type graphSymbol struct {
+	ID   string `json:"id"`
+	Char string `json:"char"`
+	Row  int    `json:"row"`
+	Col  int    `json:"col"`
+}
+
+type graphNumber struct {
+	ID     string `json:"id"`
+	Value  int    `json:"value"`
+	Row    int    `json:"row"`
+	Col    int    `json:"col"`
+	Length int    `json:"length"`
+}
+
+type graphEdge struct {
+	Symbol string `json:"symbol"`
+	Number string `json:"number"`
+}
+
+type graphComponent struct {
+	Symbols []string `json:"symbols"`
+	Numbers []string `json:"numbers"`
+	Bridged bool     `json:"bridged"`
+}
+
+func symbolID(i int) string {
+	return fmt.Sprintf("s%d", i)
+}
+
+func numberID(i int) string {
+	return fmt.Sprintf("n%d", i)
+}
+
+// WriteGraph writes the bipartite graph of symbols and part numbers to w, in
+// the given format: dot (for Graphviz) or json.  Every node carries its grid
+// coordinates.  Bridged components (numbers connecting several symbols) are
+// drawn as clusters in DOT, and flagged in JSON.
+func (a *Analysis) WriteGraph(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return a.writeGraphJSON(w)
+	case "dot":
+		return a.writeGraphDOT(w)
+	}
+	return fmt.Errorf("invalid graph format: %s (expected dot or json)", format)
+}
+
+func (a *Analysis) writeGraphJSON(w io.Writer) error {
+	graph := graphJSON{
+		Symbols:    []graphSymbol{},
+		Numbers:    []graphNumber{},
+		Edges:      []graphEdge{},
+		Components: []graphComponent{},
+	}
+
+	for i, symbol := range a.Symbols {
+		graph.Symbols = append(graph.Symbols, graphSymbol{ID: symbolID(i), Char: string(symbol.Char), Row: symbol.Pos.Row, Col: symbol.Pos.Col})
+		for _, number := range symbol.Numbers {
+			graph.Edges = append(graph.Edges, graphEdge{Symbol: symbolID(i), Number: numberID(number)})
+		}
+	}
+	for i, number := range a.Numbers {
+		if number.IsPart() {
+			graph.Numbers = append(graph.Numbers, graphNumber{ID: numberID(i), Value: number.Value, Row: number.Pos.Row, Col: number.Pos.Col, Length: number.Length})
+		}
+	}
+	for _, component := range a.Components() {
+		c := graphComponent{Symbols: []string{}, Numbers: []string{}, Bridged: component.Bridged()}
+		for _, symbol := range component.Symbols {
+			c.Symbols = append(c.Symbols, symbolID(symbol))
+		}
+		for _, number := range component.Numbers {
+			c.Numbers = append(c.Numbers, numberID(number))
+		}
+		graph.Components = append(graph.Components, c)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(graph)
+}
+
+func (a *Analysis) writeGraphDOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, "graph schematic {")
+	fmt.Fprintln(out, "  node [fontname=\"monospace\"];")
+
+	for i, symbol := range a.Symbols {
+		fmt.Fprintf(out, "  %s [label=%q, shape=box];\n", symbolID(i), fmt.Sprintf("%c (%d,%d)", symbol.Char, symbol.Pos.Row, symbol.Pos.Col))
+	}
+	for i, number := range a.Numbers {
+		if number.IsPart() {
+			fmt.Fprintf(out, "  %s [label=%q, shape=ellipse];\n", numberID(i), fmt.Sprintf("%d (%d,%d)", number.Value, number.Pos.Row, number.Pos.Col))
+		}
+	}
+
+	cluster := 0
+	for _, component := range a.Components() {
+		if !component.Bridged() {
+			continue
+		}
+		fmt.Fprintf(out, "  subgraph cluster_%d {\n", cluster)
+		fmt.Fprintln(out, "    style=dashed;")
+		for _, symbol := range component.Symbols {
+			fmt.Fprintf(out, "    %s;\n", symbolID(symbol))
+		}
+		for _, number := range component.Numbers {
+			fmt.Fprintf(out, "    %s;\n", numberID(number))
+		}
+		fmt.Fprintln(out, "  }")
+		cluster++
+	}
+
+	for i, symbol := range a.Symbols {
+		for _, number := range symbol.Numbers {
+			fmt.Fprintf(out, "  %s -- %s;\n", symbolID(i), numberID(number))
+		}
+	}
+
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// WriteStats writes a summary of the analysis to w: for each kind of symbol,
+// how many there are and a histogram of how many part numbers each touches;
+// the smallest and largest part numbers; the numbers touching more than one
+// symbol; and the isolated numbers that touch no symbol at all.
+func (a *Analysis) WriteStats(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	partCount := 0
+	for _, number := range a.Numbers {
+		if number.IsPart() {
+			partCount++
+		}
+	}
+	fmt.Fprintf(out, "Numbers: %d (%d part numbers, %d isolated)\n", len(a.Numbers), partCount, len(a.Numbers)-partCount)
+	fmt.Fprintf(out, "Symbols: %d\n", len(a.Symbols))
+	fmt.Fprintf(out, "Gears: %d\n", len(a.Gears))
+
+	// Count the symbols of each kind, and how many numbers each one touches
+	counts := make(map[byte]int)
+	histograms := make(map[byte]map[int]int)
+	for _, symbol := range a.Symbols {
+		counts[symbol.Char]++
+		if histograms[symbol.Char] == nil {
+			histograms[symbol.Char] = make(map[int]int)
+		}
+		histograms[symbol.Char][len(symbol.Numbers)]++
+	}
+
+	chars := make([]byte, 0, len(counts))
+	for char := range counts {
+		chars = append(chars, char)
+	}
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Symbol  Count  Symbols touching N part numbers (N: symbols)")
+	for _, char := range chars {
+		touched := make([]int, 0, len(histograms[char]))
+		for n := range histograms[char] {
+			touched = append(touched, n)
+		}
+		sort.Ints(touched)
 
-			println("")
+		histogram := make([]string, len(touched))
+		for i, n := range touched {
+			histogram[i] = fmt.Sprintf("%d: %d", n, histograms[char][n])
 		}
+		fmt.Fprintf(out, "%-6c  %5d  %s\n", char, counts[char], strings.Join(histogram, ", "))
+	}
+
+	// The smallest and largest part numbers, and those touching more than
+	// one symbol
+	smallest, largest := -1, -1
+	var shared, isolated []string
+	for i, number := range a.Numbers {
+		if !number.IsPart() {
+			isolated = append(isolated, fmt.Sprintf("%d at (%d,%d)", number.Value, number.Pos.Row, number.Pos.Col))
+			continue
+		}
+		if smallest == -1 || number.Value < a.Numbers[smallest].Value {
+			smallest = i
+		}
+		if largest == -1 || number.Value > a.Numbers[largest].Value {
+			largest = i
+		}
+		if len(number.Symbols) > 1 {
+			shared = append(shared, fmt.Sprintf("%d at (%d,%d) touches %d symbols", number.Value, number.Pos.Row, number.Pos.Col, len(number.Symbols)))
+		}
+	}
+
+	fmt.Fprintln(out)
+	if smallest != -1 {
+		fmt.Fprintf(out, "Smallest part number: %d at (%d,%d)\n", a.Numbers[smallest].Value, a.Numbers[smallest].Pos.Row, a.Numbers[smallest].Pos.Col)
+		fmt.Fprintf(out, "Largest part number: %d at (%d,%d)\n", a.Numbers[largest].Value, a.Numbers[largest].Pos.Row, a.Numbers[largest].Pos.Col)
+	}
+
+	fmt.Fprintf(out, "\nNumbers touching more than one symbol: %d\n", len(shared))
+	for _, line := range shared {
+		fmt.Fprintln(out, "  "+line)
+	}
+
+	fmt.Fprintf(out, "\nIsolated numbers: %d\n", len(isolated))
+	for _, line := range isolated {
+		fmt.Fprintln(out, "  "+line)
+	}
+
+	return out.Flush()
+}
+
+// A StreamAnalyzer finds part numbers and gears in a schematic as it's read,
+// row by row.  With an adjacency radius of k, a row's part numbers and gears
+// are known once the k rows below it have been read, so only 2k+1 rows are
+// kept in memory at any time.  The results are the same as FindPartNumbers',
+// and are reported in the same order, by calling PartNumber for each part
+// number and Gear for each gear (either may be nil).  The Symbols of the
+// numbers reported aren't filled in.  As with NewSchematic, the first problem
+// with the input is returned as an error, unless Pad is set, in which case
+// short rows are treated as if padded with blanks.
+type StreamAnalyzer struct {
+	Rules      Rules
+	Adjacency  grid.Neighborhood
+	Pad        bool
+	PartNumber func(Number)
+	Gear       func(Gear)
+
+	offsets []grid.Point
+	rows    [][]byte
+	read    int
+}
+
+// Run reads and analyzes a schematic from r.
+func (a *StreamAnalyzer) Run(r io.Reader) error {
+	k := a.Adjacency.Radius
+	a.offsets = a.Adjacency.Offsets()
+	a.rows = make([][]byte, 2*k+1)
+	a.read = 0
+
+	var checker lineChecker
+	scanner := grid.NewScanner(r)
+	for scanner.Scan() {
+		line, issues := checker.check(scanner.Bytes())
+		if err := firstIssue(issues, a.Pad); err != nil {
+			return err
+		}
+
+		// Reuse the buffer of the row that just scrolled out of the window
+		slot := a.read % len(a.rows)
+		a.rows[slot] = append(a.rows[slot][:0], line...)
+		a.read++
 
-		// If the symbol character `char` is an asterisk (*), and the number of elements in the
-		// oneSymPartNumbers array is exactly 2, then multiply the two numbers together and
-		// add that to a sum variable
-		if char == '*' && len(oneSymPartNumbers) == 2 {
-			println("Found gear symbol!")
-			num1, err := strconv.Atoi(oneSymPartNumbers[0])
-			if err != nil {
-				log.Fatal(err)
+		if a.read > k {
+			a.finishRow(a.read - 1 - k)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := firstIssue(checker.finish(), a.Pad); err != nil {
+		return err
+	}
+
+	// The last k rows have nothing more below them
+	for row := maxInt(a.read-k, 0); row < a.read; row++ {
+		a.finishRow(row)
+	}
+
+	return nil
+}
+
+// Return the cell at p, if its row is still in the window.  Cells past the
+// end of a short row don't exist, which is the same as their being blank.
+func (a *StreamAnalyzer) at(p grid.Point) (byte, bool) {
+	if p.Row < 0 || p.Row >= a.read || p.Row < a.read-len(a.rows) || p.Col < 0 || p.Col >= len(a.rows[p.Row%len(a.rows)]) {
+		return 0, false
+	}
+	return a.rows[p.Row%len(a.rows)][p.Col], true
+}
+
+// Return the number with a digit at p, if there is one.
+func (a *StreamAnalyzer) numberAt(p grid.Point) (Number, bool) {
+	if char, ok := a.at(p); !ok || !isNumber(char) {
+		return Number{}, false
+	}
+
+	row := a.rows[p.Row%len(a.rows)]
+	start := p.Col
+	for start > 0 && isNumber(row[start-1]) {
+		start--
+	}
+
+	number := Number{Pos: grid.Point{Row: p.Row, Col: start}}
+	for c := start; c < len(row) && isNumber(row[c]); c++ {
+		number.Value = number.Value*10 + int(row[c]-'0')
+		number.Length++
+	}
+	return number, true
+}
+
+// Report the part numbers and gears on a row, now that every row within
+// reach of it has been read.
+func (a *StreamAnalyzer) finishRow(r int) {
+	row := a.rows[r%len(a.rows)]
+
+	// Part numbers: any digit with a symbol in its neighborhood.  The
+	// neighborhood is symmetric, so that's the same as the digit being in
+	// the symbol's neighborhood.
+	for c := 0; c < len(row); c++ {
+		if !isNumber(row[c]) {
+			continue
+		}
+
+		number, _ := a.numberAt(grid.Point{Row: r, Col: c})
+		isPart := false
+		for d := 0; d < number.Length && !isPart; d++ {
+			for _, offset := range a.offsets {
+				if char, ok := a.at(grid.Point{Row: r, Col: c + d}.Add(offset)); ok && a.Rules.IsSymbol(char) {
+					isPart = true
+					break
+				}
+			}
+		}
+		if isPart && a.PartNumber != nil {
+			a.PartNumber(number)
+		}
+
+		c += number.Length - 1
+	}
+
+	// Gears: symbols whose surrounding numbers match an aggregation rule
+	for c := 0; c < len(row); c++ {
+		if !a.Rules.IsSymbol(row[c]) {
+			continue
+		}
+
+		var numbers []Number
+		for _, offset := range a.offsets {
+			number, ok := a.numberAt(grid.Point{Row: r, Col: c}.Add(offset))
+			if !ok {
+				continue
 			}
-			num2, err := strconv.Atoi(oneSymPartNumbers[1])
-			if err != nil {
-				log.Fatal(err)
+			duplicate := false
+			for _, other := range numbers {
+				duplicate = duplicate || other.Pos == number.Pos
 			}
-			product := num1 * num2
-			ratioSum += product
-			println("Found gear symbol! ", num1, " * ", num2, " = ", product, " new sum: ", ratioSum)
+			if !duplicate {
+				numbers = append(numbers, number)
+			}
+		}
+		sort.Slice(numbers, func(i, j int) bool { return numbers[i].Pos.Less(numbers[j].Pos) })
+
+		values := make([]int, len(numbers))
+		for i, number := range numbers {
+			values[i] = number.Value
+		}
+		if value, ok := a.Rules.Aggregate(row[c], values); ok && a.Gear != nil {
+			a.Gear(Gear{Pos: grid.Point{Row: r, Col: c}, Char: row[c], Values: values, Value: value})
+		}
+	}
+}
+
+// CompareAnalyzers analyzes a schematic both with FindPartNumbers and with a
+// StreamAnalyzer, returning an error describing the first difference between
+// the part numbers or gears they find.  If padding is non-zero, short rows are
+// padded with it.
+func CompareAnalyzers(input []byte, rules Rules, adjacency grid.Neighborhood, padding byte) error {
+	var schematic *Schematic
+	var err error
+	if padding != 0 {
+		schematic, err = NewPaddedSchematic(bytes.NewReader(input), padding)
+	} else {
+		schematic, err = NewSchematic(bytes.NewReader(input))
+	}
+	if err != nil {
+		return err
+	}
+	schematic.Rules = rules
+	schematic.Adjacency = adjacency
+	analysis := schematic.FindPartNumbers()
+
+	var wholeNumbers, streamNumbers []Number
+	for _, number := range analysis.Numbers {
+		if number.IsPart() {
+			number.Symbols = nil
+			wholeNumbers = append(wholeNumbers, number)
 		}
+	}
+
+	var streamGears []Gear
+	analyzer := StreamAnalyzer{
+		Rules:      rules,
+		Adjacency:  adjacency,
+		Pad:        padding != 0,
+		PartNumber: func(number Number) { streamNumbers = append(streamNumbers, number) },
+		Gear:       func(gear Gear) { streamGears = append(streamGears, gear) },
+	}
+	if err := analyzer.Run(bytes.NewReader(input)); err != nil {
+		return err
+	}
 
-		allPartNumbers = append(allPartNumbers, oneSymPartNumbers...)
+	if !reflect.DeepEqual(wholeNumbers, streamNumbers) {
+		return fmt.Errorf("part numbers differ: whole-grid found %d, streaming found %d\nwhole-grid: %v\nstreaming:  %v",
+			len(wholeNumbers), len(streamNumbers), wholeNumbers, streamNumbers)
+	}
+	if !reflect.DeepEqual(analysis.Gears, streamGears) {
+		return fmt.Errorf("gears differ: whole-grid found %d, streaming found %d\nwhole-grid: %v\nstreaming:  %v",
+			len(analysis.Gears), len(streamGears), analysis.Gears, streamGears)
 	}
 
-	return oneSymPartNumbers
+	return nil
 }
 
-// isNumber checks if a character is a digit.
-func isNumber(char byte) bool {
-	return char >= '0' && char <= '9'
+// A GeneratorConfig describes the random schematics made by
+// GenerateSchematic.  The densities are the number of numbers, symbols and
+// gears to try to place per cell; placements that would collide with what's
+// already there are skipped.
+type GeneratorConfig struct {
+	Width         int
+	Height        int
+	NumberDensity float64
+	SymbolDensity float64
+	GearDensity   float64
+	Seed          int64
 }
 
-func FindNumbersAt(line string, index int, lineNum int) []string {
-	var numbers []string
+// A GeneratedSchematic is the text of a random schematic, along with its
+// expected part number and gear ratio sums.
+type GeneratedSchematic struct {
+	Text          []byte
+	PartNumberSum int
+	GearRatioSum  int
+}
 
-	c1 := index
+// Symbols placed by the generator that aren't gears.
+const generatorSymbols = "#$%&+-/=@"
 
-	// Check if this location has already been checked
-	if checkedLocations[lineNum*width+c1] {
-		return numbers
+// GenerateSchematic makes a random schematic.  Gears are placed first, each as
+// an asterisk (*) with a number on either side of it; then single numbers of
+// one to three digits; then single symbols.  A number is only placed where
+// neither it nor the cells either side of it hold a digit, so numbers never
+// run together.  Later placements can still touch earlier ones, so a gear may
+// end up with more than two numbers.
+//
+// The expected sums are worked out from the placed numbers and symbols
+// themselves, by their distance from each other, rather than from the text, so
+// they're independent of both FindPartNumbers and StreamAnalyzer.  The same
+// seed always produces the same schematic.
+func GenerateSchematic(config GeneratorConfig, rules Rules, adjacency grid.Neighborhood) (GeneratedSchematic, error) {
+	if config.Width < 1 || config.Height < 1 {
+		return GeneratedSchematic{}, fmt.Errorf("invalid size %dx%d", config.Width, config.Height)
+	}
+	if rules.Blank == "" {
+		return GeneratedSchematic{}, fmt.Errorf("generating a schematic needs at least one blank character in the rules")
 	}
 
-	if c1 >= 0 && c1 < len(line) && isNumber(line[c1]) {
-		checkedLocations[lineNum*width+c1] = true
-		partNumber := ""
+	rng := rand.New(rand.NewSource(config.Seed))
+	blank := rules.Blank[0]
+	cells := grid.NewGrid[byte](config.Width, config.Height)
+	cells.Scan(func(p grid.Point, _ byte) { cells.Set(p, blank) })
+
+	var numbers []Number
+	var symbols []Symbol
+
+	// Place a number if it fits, returning whether it did
+	placeNumber := func(p grid.Point, length int) bool {
+		if p.Col < 0 || p.Col+length > config.Width {
+			return false
+		}
+		for c := p.Col - 1; c <= p.Col+length; c++ {
+			char, _ := cells.At(grid.Point{Row: p.Row, Col: c})
+			if isNumber(char) || (c >= p.Col && c < p.Col+length && char != blank) {
+				return false
+			}
+		}
 
-		// Check for digit characters forward and backward
-		for c1 := index; c1 >= 0 && isNumber(line[c1]); c1-- {
-			checkedLocations[lineNum*width+c1] = true
-			partNumber = string(line[c1]) + partNumber
+		// No leading zeros, so the text reads back as the same value
+		value := 1 + rng.Intn(9)
+		for i := 1; i < length; i++ {
+			value = value*10 + rng.Intn(10)
 		}
-		for c1 := index + 1; c1 < len(line) && isNumber(line[c1]); c1++ {
-			checkedLocations[lineNum*width+c1] = true
-			partNumber += string(line[c1])
+		digits := strconv.Itoa(value)
+		for i := 0; i < length; i++ {
+			cells.Set(grid.Point{Row: p.Row, Col: p.Col + i}, digits[i])
 		}
-		println("+++ Part number:", partNumber)
-		numbers = append(numbers, partNumber)
+		numbers = append(numbers, Number{Pos: p, Length: length, Value: value})
+		return true
 	}
 
-	return numbers
+	placeSymbol := func(p grid.Point, char byte) bool {
+		if current, ok := cells.At(p); !ok || current != blank {
+			return false
+		}
+		cells.Set(p, char)
+		symbols = append(symbols, Symbol{Pos: p, Char: char})
+		return true
+	}
+
+	randomPoint := func() grid.Point {
+		return grid.Point{Row: rng.Intn(config.Height), Col: rng.Intn(config.Width)}
+	}
+
+	area := float64(config.Width * config.Height)
+	for i := 0; i < int(config.GearDensity*area); i++ {
+		p := randomPoint()
+		leftLength, rightLength := 1+rng.Intn(3), 1+rng.Intn(3)
+		left, right := grid.Point{Row: p.Row, Col: p.Col - leftLength}, grid.Point{Row: p.Row, Col: p.Col + 1}
+
+		// Only place the gear if both of its numbers fit
+		if current, ok := cells.At(p); !ok || current != blank {
+			continue
+		}
+		cells.Set(p, '*')
+		if !placeNumber(left, leftLength) {
+			cells.Set(p, blank)
+			continue
+		}
+		if !placeNumber(right, rightLength) {
+			for c := 0; c < leftLength; c++ {
+				cells.Set(grid.Point{Row: p.Row, Col: left.Col + c}, blank)
+			}
+			numbers = numbers[:len(numbers)-1]
+			cells.Set(p, blank)
+			continue
+		}
+		symbols = append(symbols, Symbol{Pos: p, Char: '*'})
+	}
+	for i := 0; i < int(config.NumberDensity*area); i++ {
+		placeNumber(randomPoint(), 1+rng.Intn(3))
+	}
+	for i := 0; i < int(config.SymbolDensity*area); i++ {
+		placeSymbol(randomPoint(), generatorSymbols[rng.Intn(len(generatorSymbols))])
+	}
+
+	// Work out the answers from the placed numbers and symbols, in position
+	// order, matching the order the analyzers use for a gear's numbers
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i].Pos.Less(numbers[j].Pos) })
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Pos.Less(symbols[j].Pos) })
+
+	generated := GeneratedSchematic{}
+	isPart := make([]bool, len(numbers))
+	for _, symbol := range symbols {
+		if !rules.IsSymbol(symbol.Char) {
+			continue
+		}
+
+		var values []int
+		for i, number := range numbers {
+			if numberWithin(number, symbol.Pos, adjacency) {
+				isPart[i] = true
+				values = append(values, number.Value)
+			}
+		}
+		if value, ok := rules.Aggregate(symbol.Char, values); ok {
+			generated.GearRatioSum += value
+		}
+	}
+	for i, number := range numbers {
+		if isPart[i] {
+			generated.PartNumberSum += number.Value
+		}
+	}
+
+	var text bytes.Buffer
+	for r := 0; r < config.Height; r++ {
+		text.Write(cells.Row(r))
+		text.WriteByte('\n')
+	}
+	generated.Text = text.Bytes()
+
+	return generated, nil
+}
+
+// Report whether any digit of a number is within the neighborhood of p,
+// measuring the distance to the nearest digit directly.
+func numberWithin(number Number, p grid.Point, n grid.Neighborhood) bool {
+	rowDistance := absInt(number.Pos.Row - p.Row)
+	colDistance := 0
+	if p.Col < number.Pos.Col {
+		colDistance = number.Pos.Col - p.Col
+	} else if last := number.Pos.Col + number.Length - 1; p.Col > last {
+		colDistance = p.Col - last
+	}
+
+	if n.Metric == "manhattan" {
+		return rowDistance+colDistance <= n.Radius
+	}
+	return maxInt(rowDistance, colDistance) <= n.Radius
+}
+
+// Fuzz generates count random schematics, starting from config.Seed and
+// adding one for each, and checks that FindPartNumbers and StreamAnalyzer
+// both find the expected sums, and agree with each other in detail.  It
+// returns an error naming the seed of the first schematic that fails.
+func Fuzz(config GeneratorConfig, count int, rules Rules, adjacency grid.Neighborhood) error {
+	for i := 0; i < count; i++ {
+		seedConfig := config
+		seedConfig.Seed = config.Seed + int64(i)
+
+		generated, err := GenerateSchematic(seedConfig, rules, adjacency)
+		if err != nil {
+			return err
+		}
+
+		schematic, err := NewSchematic(bytes.NewReader(generated.Text))
+		if err != nil {
+			return fmt.Errorf("seed %d: %w", seedConfig.Seed, err)
+		}
+		schematic.Rules = rules
+		schematic.Adjacency = adjacency
+
+		partSum := 0
+		for _, num := range schematic.PartNumbers() {
+			partSum += num
+		}
+		if partSum != generated.PartNumberSum || schematic.GearRatioSum() != generated.GearRatioSum {
+			return fmt.Errorf("seed %d: whole-grid sums %d and %d, expected %d and %d",
+				seedConfig.Seed, partSum, schematic.GearRatioSum(), generated.PartNumberSum, generated.GearRatioSum)
+		}
+
+		if err := CompareAnalyzers(generated.Text, rules, adjacency, 0); err != nil {
+			return fmt.Errorf("seed %d: %w", seedConfig.Seed, err)
+		}
+	}
+
+	return nil
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/misterdorm/aoc-2023/grid"
+)
+
+func sum(values []int) int {
+	total := 0
+	for _, value := range values {
+		total += value
+	}
+	return total
+}
+
+func TestSampleInput(t *testing.T) {
+	f, err := os.Open("sample-input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	schematic, err := NewSchematic(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sum(schematic.PartNumbers()); got != 4361 {
+		t.Errorf("sum of part numbers = %d, want 4361", got)
+	}
+	if got := schematic.GearRatioSum(); got != 467835 {
+		t.Errorf("gear ratio sum = %d, want 467835", got)
+	}
+}
+
+// A number touching several symbols, or one symbol with several digits, is
+// only counted once, and every symbol on a line counts, not just the last.
+func TestPartNumbersCountedOnce(t *testing.T) {
+	input := "" +
+		"12.$5..\n" +
+		"#...*..\n" +
+		"..34.3.\n"
+
+	schematic, err := NewSchematic(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := schematic.PartNumbers()
+	want := []int{12, 5, 34, 3}
+	if len(got) != len(want) {
+		t.Fatalf("part numbers = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("part numbers = %v, want %v", got, want)
+		}
+	}
+}
+
+// The streaming analyzer finds the same part numbers and gears as the
+// whole-grid one, for the sample and for generated schematics, with the usual
+// neighborhood and with larger ones.
+func TestStreamMatchesWholeGrid(t *testing.T) {
+	sample, err := os.ReadFile("sample-input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, adjacency := range []string{"8", "4", "chebyshev:2", "manhattan:3"} {
+		neighborhood, err := grid.ParseNeighborhood(adjacency)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := CompareAnalyzers(sample, DefaultRules(), neighborhood, 0); err != nil {
+			t.Errorf("sample, adjacency %s: %v", adjacency, err)
+		}
+
+		config := GeneratorConfig{Width: 60, Height: 40, NumberDensity: 0.08, SymbolDensity: 0.03, GearDensity: 0.01}
+		for seed := int64(1); seed <= 5; seed++ {
+			config.Seed = seed
+			generated, err := GenerateSchematic(config, DefaultRules(), neighborhood)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := CompareAnalyzers(generated.Text, DefaultRules(), neighborhood, 0); err != nil {
+				t.Errorf("seed %d, adjacency %s: %v", seed, adjacency, err)
+			}
+		}
+	}
+}
+
+// Rows longer than bufio.Scanner's default 64 KiB limit can still be read.
+func TestWideRows(t *testing.T) {
+	config := GeneratorConfig{Width: 100000, Height: 3, NumberDensity: 0.08, SymbolDensity: 0.03, GearDensity: 0.01, Seed: 1}
+	neighborhood := grid.Neighborhood{Metric: "chebyshev", Radius: 1}
+	generated, err := GenerateSchematic(config, DefaultRules(), neighborhood)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partSum := 0
+	analyzer := StreamAnalyzer{
+		Rules:      DefaultRules(),
+		Adjacency:  neighborhood,
+		PartNumber: func(number Number) { partSum += number.Value },
+	}
+	if err := analyzer.Run(bytes.NewReader(generated.Text)); err != nil {
+		t.Fatal(err)
+	}
+	if partSum != generated.PartNumberSum {
+		t.Errorf("sum of part numbers = %d, want %d", partSum, generated.PartNumberSum)
+	}
+
+	if err := CompareAnalyzers(generated.Text, DefaultRules(), neighborhood, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+// Rules built in code aren't necessarily validated; an aggregation without a
+// single symbol character is ignored rather than crashing the analysis.
+func TestUnvalidatedAggregation(t *testing.T) {
+	sample, err := os.ReadFile("sample-input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := DefaultRules()
+	rules.Aggregations = append([]Aggregation{{Symbol: "", Compare: "==", Count: 2, Op: "sum"}}, rules.Aggregations...)
+
+	schematic, err := NewSchematic(bytes.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schematic.Rules = rules
+	if got := schematic.GearRatioSum(); got != 467835 {
+		t.Errorf("gear ratio sum = %d, want 467835", got)
+	}
+
+	if err := CompareAnalyzers(sample, rules, grid.Neighborhood{Metric: "chebyshev", Radius: 1}, 0); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/misterdorm/aoc-2023/day04
+
+go 1.21
@@ -10,6 +10,44 @@
 // get one copy each of the following four cards (Card 2, Card 3, Card 4, and Card 5).
 // Each card, and copies of cards, are processed in order until we reach a card
 // that grants no additional copies.  Determine the total number of cards.
+//
+// Part 1 (see INSTRUCTIONS.md) scores each card instead: the first match is
+// worth one point, and each match after the first doubles the card's points.
+// Determine the total points.  Both totals are computed from the same parsed
+// cards; use -part 1 or -part 2 to print only one of them.
+//
+// Copies grow exponentially along a chain of cards with many matches, and
+// points with the number of matches on a card, so if the card counts or points
+// overflow an int they're recomputed with math/big (or always, with -big).
+// overflow-input.txt is such a chain: 100 cards of 10 matches each, except
+// that the last ten have fewer, so that no card wins copies of cards past the
+// last one.  That's a total of 1214081766856297026980371898358 cards.
+//
+// A number repeated within one of a card's lists counts once for each time it
+// appears (a winning number listed twice matches twice), unless -duplicates
+// once is given, in which case each number counts once, or -duplicates error,
+// which rejects such cards.
+//
+// The puzzle promises that cards never win copies of cards past the end of the
+// table, but inputs don't always keep that promise.  By default such copies
+// are counted in the total; -beyond clip discards them (reporting how many
+// were discarded), and -beyond error rejects the input.
+//
+// -explain prints where each card's copies came from: the original card, plus
+// the copies won from each earlier card, sorted with the most copied cards
+// first.  -dot <file> writes the same cascade as a Graphviz graph.
+//
+// With -generate, no input file is read; instead a random table of cards is
+// written to stdout, and its expected totals to stderr (see generateCards).
+//
+// With -whatif, the cards are read and then commands are read from stdin, to
+// see how the totals change when a card does (see Cascade):
+//
+//	set 12 41 48 | 83 86   replace card 12's numbers
+//	card 12                print card 12's matches and copies
+//	total                  print the total cards
+//	points                 print the total points
+//	quit
 
 // Sample input file:
 // Card 1: 41 48 83 86 17 | 83 86  6 31 17  9 48 53
@@ -22,121 +60,972 @@
 package main
 
 // Define a main function that opens the file, reads each line
-// and parses the card number, and the two lists of numbers.
-// The numbers need not be converted to ints, they can be strings.
-// Each list of number should be stored in a slice of strings and
-// the card number should be stored in a string variable.
-
-// The main function should then call a function that takes the
-// card number and the two lists of numbers as arguments and returns
-// the count of numbers found in the second list.
-// The main function should print out the card number and the count
-// of numbers found.
+// and parses the card number, and the two lists of numbers into a
+// Scratchcard.  Once every card has been read, total up the points
+// for part 1, and the cards (including copies) for part 2.
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+	"math/rand"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 )
 
+// A Scratchcard is one parsed line of input: the card number, the winning
+// numbers, and the numbers we have.  The winning numbers are kept as a set
+// (with a count of how many times each was listed), so counting matches is a
+// single pass over the numbers we have.
+type Scratchcard struct {
+	ID      int
+	Winning map[int]int
+	Have    []int
+}
+
+// Matches returns how many of the numbers we have are winning numbers.  A
+// number listed more than once matches once per listing on each side.
+func (c Scratchcard) Matches() int {
+	count := 0
+	for _, num := range c.Have {
+		count += c.Winning[num]
+	}
+	return count
+}
+
+// How numbers repeated within one of a card's lists are treated.
+type duplicatePolicy int
+
+const (
+	duplicatesMultiply duplicatePolicy = iota // each listing counts
+	duplicatesOnce                            // each number counts once
+	duplicatesError                           // repeated numbers are an error
+)
+
+func parseDuplicatePolicy(s string) (duplicatePolicy, error) {
+	switch s {
+	case "multiply":
+		return duplicatesMultiply, nil
+	case "once":
+		return duplicatesOnce, nil
+	case "error":
+		return duplicatesError, nil
+	}
+	return 0, fmt.Errorf("invalid duplicate policy: %s (expected multiply, once or error)", s)
+}
+
+// What to do with copies won of cards past the end of the table.
+type beyondPolicy int
+
+const (
+	beyondCount beyondPolicy = iota // count them in the total
+	beyondClip                      // discard them
+	beyondError                     // reject the input
+)
+
+func parseBeyondPolicy(s string) (beyondPolicy, error) {
+	switch s {
+	case "count":
+		return beyondCount, nil
+	case "clip":
+		return beyondClip, nil
+	case "error":
+		return beyondError, nil
+	}
+	return 0, fmt.Errorf("invalid policy for copies past the last card: %s (expected count, clip or error)", s)
+}
+
+// Return an error naming the first card that wins copies of cards past the
+// end of the table, or nil if there isn't one.
+func checkBeyond(cards []Scratchcard) error {
+	for i, card := range cards {
+		if i+card.Matches() >= len(cards) {
+			return fmt.Errorf("card %d wins copies of cards past the last card (%d)", card.ID, cards[len(cards)-1].ID)
+		}
+	}
+	return nil
+}
+
+// Points returns what the card is worth: one point for the first match,
+// doubled for each match after that.  A card with more matches than an int
+// has bits returns errOverflow; PointsBig gives the right answer in that case.
+func (c Scratchcard) Points() (int, error) {
+	count := c.Matches()
+	if count == 0 {
+		return 0, nil
+	}
+	if count-1 >= bits.UintSize-1 {
+		return 0, errOverflow
+	}
+	return 1 << (count - 1), nil
+}
+
+// The same as Points, but with arbitrary-precision integers, so it never
+// overflows.
+func (c Scratchcard) PointsBig() *big.Int {
+	count := c.Matches()
+	if count == 0 {
+		return new(big.Int)
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(count-1))
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("Usage: solution <input file>")
+	part := flag.Int("part", 0, "print only the part 1 (points) or part 2 (cards) total")
+	useBig := flag.Bool("big", false, "always count cards with arbitrary-precision integers")
+	duplicates := flag.String("duplicates", "multiply", "how repeated numbers within a list count: multiply, once or error")
+	beyondFlag := flag.String("beyond", "count", "what to do with copies of cards past the last card: count, clip or error")
+	explain := flag.Bool("explain", false, "print where each card's copies came from")
+	dotFile := flag.String("dot", "", "write the card cascade to a Graphviz dot file")
+	generate := flag.Bool("generate", false, "write a random table of cards to stdout instead of reading one")
+	numCards := flag.Int("cards", 200, "number of cards for -generate")
+	numWinning := flag.Int("winning", 10, "winning numbers per card for -generate")
+	numHave := flag.Int("have", 25, "numbers we have per card for -generate")
+	maxNumber := flag.Int("max", 99, "numbers range from 1 to max for -generate")
+	distribution := flag.String("dist", "uniform", "distribution of matches per card for -generate: uniform, geometric:p or fixed:k")
+	seed := flag.Int64("seed", 1, "random seed for -generate")
+	whatIf := flag.Bool("whatif", false, "read commands from stdin to change cards and recount the totals")
+	flag.Parse()
+
+	if *generate {
+		config := GeneratorConfig{
+			Cards:        *numCards,
+			Winning:      *numWinning,
+			Have:         *numHave,
+			MaxNumber:    *maxNumber,
+			Distribution: *distribution,
+			Seed:         *seed,
+		}
+		if err := runGenerate(config); err != nil {
+			fmt.Println("Error generating cards:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() != 1 || *part < 0 || *part > 2 {
+		fmt.Println("Usage: solution [-part 1|2] [-big] [-duplicates multiply|once|error] [-beyond count|clip|error] [-explain] [-dot <file>] [-whatif] <input file>")
+		fmt.Println("       solution -generate [-cards n] [-winning n] [-have n] [-max n] [-dist uniform|geometric:p|fixed:k] [-seed n]")
+		os.Exit(1)
+	}
+
+	policy, err := parseDuplicatePolicy(*duplicates)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	beyond, err := parseBeyondPolicy(*beyondFlag)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
 	// Open the file
-	f, err := os.Open(os.Args[1])
+	f, err := os.Open(flag.Arg(0))
 	if err != nil {
 		fmt.Println("Error opening file:", err)
 		os.Exit(1)
 	}
 
-	// Map for tracking how many copies of each card we have
-	cards := make(map[int]int)
+	// Parse every card before totalling
+	cards, err := parseCards(f, policy)
+	f.Close()
+	if err != nil {
+		fmt.Println("Error reading cards:", err)
+		os.Exit(1)
+	}
+
+	if *whatIf {
+		if beyond == beyondError {
+			if err := checkBeyond(cards); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		}
+		if err := runWhatIf(NewCascade(cards, beyond), policy, os.Stdin, os.Stdout); err != nil {
+			fmt.Println("Error reading commands:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Read the file one line at a time
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// Parse the line into the card number, and the two lists of numbers
-		card, list1, list2 := parseLine(line)
+	if *part != 2 {
+		// Print the total points
+		fmt.Printf("Points: %s\n", countAllPoints(cards, *useBig))
+	}
+
+	if *part != 1 {
+		if beyond == beyondError {
+			if err := checkBeyond(cards); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		}
 
-		// Call a function that takes the card number and the two lists of numbers
-		// as arguments and returns the count of numbers found in the second list.
-		count := countMatches(list1, list2)
+		copies, total, past := countAllCards(cards, beyond, *useBig)
 
-		println("======================")
-		fmt.Printf("Card %d: Matches: %d\n", card, count)
+		if *explain || *dotFile != "" {
+			provenance := explainCascade(cards, copies)
+			if *explain {
+				writeProvenanceTable(os.Stdout, provenance)
+			}
+			if *dotFile != "" {
+				if err := writeDOTFile(*dotFile, provenance); err != nil {
+					fmt.Println("Error writing dot file:", err)
+					os.Exit(1)
+				}
+			}
+		}
+		printBeyond(beyond, past)
 
-		// Increment the count for this card (we start at i = 0), and additional
-		// copies of following cards -- all of that for each copy of this card.
-		cardCount := cards[card]
-		cards[card]++
+		// Print the total number of cards
+		fmt.Printf("Total: %s\n", total)
+	}
+}
 
-		for j := 0; j <= cardCount; j++ {
-			for i := 1; i <= count; i++ {
-				cards[card+i]++
+// Count the cards with ints, or with big integers if that overflows (or
+// always, if useBig is set).  The counts are returned as big integers either
+// way; see countCards for what they are.
+func countAllCards(cards []Scratchcard, beyond beyondPolicy, useBig bool) ([]*big.Int, *big.Int, *big.Int) {
+	if !useBig {
+		copies, total, past, err := countCards(cards, beyond)
+		if err == nil {
+			bigCopies := make([]*big.Int, len(copies))
+			for i, c := range copies {
+				bigCopies[i] = big.NewInt(int64(c))
 			}
+			return bigCopies, big.NewInt(int64(total)), big.NewInt(int64(past))
 		}
+		fmt.Println("Card counts overflow, recounting with big integers")
+	}
 
-		// Print the contents of the `cards` map
-		keys := make([]int, 0, len(cards))
-		for key := range cards {
-			keys = append(keys, key)
+	return countCardsBig(cards, beyond)
+}
+
+// Total the points of every card, returning errOverflow if the total (or any
+// card's points) doesn't fit in an int.
+func countPoints(cards []Scratchcard) (int, error) {
+	total := 0
+	for _, card := range cards {
+		points, err := card.Points()
+		if err != nil {
+			return 0, err
 		}
-		sort.Ints(keys)
 
-		for _, card := range keys {
-			count := cards[card]
-			fmt.Printf("%d: %d\n", card, count)
+		var ok bool
+		if total, ok = addChecked(total, points); !ok {
+			return 0, errOverflow
+		}
+	}
+	return total, nil
+}
+
+// The same as countPoints, but with arbitrary-precision integers, so it never
+// overflows.
+func countPointsBig(cards []Scratchcard) *big.Int {
+	total := new(big.Int)
+	for _, card := range cards {
+		total.Add(total, card.PointsBig())
+	}
+	return total
+}
+
+// Total the points with ints, or with big integers if that overflows (or
+// always, if useBig is set), like countAllCards.
+func countAllPoints(cards []Scratchcard, useBig bool) *big.Int {
+	if !useBig {
+		total, err := countPoints(cards)
+		if err == nil {
+			return big.NewInt(int64(total))
 		}
-		println()
+		fmt.Println("Points overflow, recounting with big integers")
 	}
 
+	return countPointsBig(cards)
+}
+
+// Report how many copies of cards past the last card were won, if any, and
+// what was done with them.
+func printBeyond(beyond beyondPolicy, past *big.Int) {
+	if past.Sign() == 0 {
+		return
+	}
+	if beyond == beyondClip {
+		fmt.Printf("Discarded %s copies of cards past the last card\n", past)
+	} else {
+		fmt.Printf("Counted %s copies of cards past the last card\n", past)
+	}
+}
+
+// A Contribution is the number of copies of a card won by one earlier card (or
+// the original card itself, with From set to 0).
+type Contribution struct {
+	From   int
+	Copies *big.Int
+}
+
+// The Provenance of a card: how many copies of it we end up with, and where
+// they came from, with the largest contributions first.
+type Provenance struct {
+	Card   Scratchcard
+	Copies *big.Int
+	From   []Contribution
+}
+
+// Work out the provenance of every card, given the copies counted for each.
+// Every copy of card j wins one copy of each of the next Matches() cards, so
+// card j contributes copies[j] to each of them.  The result is sorted with
+// the most copied cards first (then by card number).
+func explainCascade(cards []Scratchcard, copies []*big.Int) []Provenance {
+	provenance := make([]Provenance, len(cards))
+	for i, card := range cards {
+		provenance[i] = Provenance{
+			Card:   card,
+			Copies: copies[i],
+			From:   []Contribution{{From: 0, Copies: big.NewInt(1)}},
+		}
+	}
+
+	for j, card := range cards {
+		for i := j + 1; i <= j+card.Matches() && i < len(cards); i++ {
+			provenance[i].From = append(provenance[i].From, Contribution{From: card.ID, Copies: copies[j]})
+		}
+	}
+
+	for _, p := range provenance {
+		sort.SliceStable(p.From, func(a, b int) bool {
+			return p.From[a].Copies.Cmp(p.From[b].Copies) > 0
+		})
+	}
+	sort.SliceStable(provenance, func(a, b int) bool {
+		if c := provenance[a].Copies.Cmp(provenance[b].Copies); c != 0 {
+			return c > 0
+		}
+		return provenance[a].Card.ID < provenance[b].Card.ID
+	})
+
+	return provenance
+}
+
+// Write the provenance of each card as a table, one card per row.
+func writeProvenanceTable(w io.Writer, provenance []Provenance) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Card\tMatches\tCopies\t  From")
+	for _, p := range provenance {
+		from := make([]string, len(p.From))
+		for i, c := range p.From {
+			if c.From == 0 {
+				from[i] = fmt.Sprintf("%s original", c.Copies)
+			} else {
+				from[i] = fmt.Sprintf("%s from card %d", c.Copies, c.From)
+			}
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t  %s\n", p.Card.ID, p.Card.Matches(), p.Copies, strings.Join(from, ", "))
+	}
+	tw.Flush()
+}
+
+// Write the cascade as a Graphviz digraph to the named file: a node for each
+// card, labelled with its copies, and an edge for each contribution from an
+// earlier card, labelled with the copies won.
+func writeDOTFile(filename string, provenance []Provenance) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "digraph cascade {")
+	for _, p := range provenance {
+		fmt.Fprintf(w, "\tcard%d [label=\"Card %d\\n%s copies\"];\n", p.Card.ID, p.Card.ID, p.Copies)
+	}
+	for _, p := range provenance {
+		for _, c := range p.From {
+			if c.From != 0 {
+				fmt.Fprintf(w, "\tcard%d -> card%d [label=\"%s\"];\n", c.From, p.Card.ID, c.Copies)
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// errOverflow is returned by countCards and countPoints (and Points) when a
+// count doesn't fit in an int.
+var errOverflow = errors.New("count overflows int")
+
+// Add two ints, reporting whether the result overflowed.
+func addChecked(a, b int) (int, bool) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, false
+	}
+	return sum, true
+}
+
+// Process each card, and its copies, in order, returning how many copies of
+// each card we end up with, the total number of cards, and how many copies
+// were won of cards past the end of the table.  Those are included in the
+// total with beyondCount, and left out of it with beyondClip (beyondError is
+// left to checkBeyond).
+//
+// Every copy of a card wins one copy of each of the following cards, so card i
+// with c copies and m matches adds c to each of cards i+1 to i+m.  Rather than
+// adding to each of those cards, we add c at i+1 and subtract it again at
+// i+m+1 in a difference array; a running sum of the difference array then
+// gives each card's copies as it's reached.  That's linear in the number of
+// cards, however many copies they have or matches they win.
+//
+// If any count overflows an int, errOverflow is returned; countCardsBig
+// gives the right answer in that case.
+func countCards(cards []Scratchcard, beyond beyondPolicy) ([]int, int, int, error) {
+	copies := make([]int, len(cards))
+	diff := make([]int, len(cards)+1)
+
 	total := 0
-	for _, value := range cards {
-		total += value
+	running := 0
+	ok := true
+	for i, card := range cards {
+		running, ok = addChecked(running, diff[i])
+		if !ok {
+			return nil, 0, 0, errOverflow
+		}
+		if copies[i], ok = addChecked(running, 1); !ok {
+			return nil, 0, 0, errOverflow
+		}
+		if total, ok = addChecked(total, copies[i]); !ok {
+			return nil, 0, 0, errOverflow
+		}
+
+		count := card.Matches()
+		if count == 0 {
+			continue
+		}
+
+		// Grow the difference array for copies past the end of the table
+		for len(diff) < i+count+2 {
+			diff = append(diff, 0)
+		}
+		if diff[i+1], ok = addChecked(diff[i+1], copies[i]); !ok {
+			return nil, 0, 0, errOverflow
+		}
+		if diff[i+count+1], ok = addChecked(diff[i+count+1], -copies[i]); !ok {
+			return nil, 0, 0, errOverflow
+		}
 	}
 
-	// Print the total number of cards
-	fmt.Printf("Total: %d\n", total)
+	// Copies of cards past the end of the table
+	past := 0
+	for i := len(cards); i < len(diff); i++ {
+		if running, ok = addChecked(running, diff[i]); !ok {
+			return nil, 0, 0, errOverflow
+		}
+		if past, ok = addChecked(past, running); !ok {
+			return nil, 0, 0, errOverflow
+		}
+	}
+	if beyond == beyondCount {
+		if total, ok = addChecked(total, past); !ok {
+			return nil, 0, 0, errOverflow
+		}
+	}
 
-	f.Close()
+	return copies, total, past, nil
+}
+
+// The same as countCards, but with arbitrary-precision integers, so it never
+// overflows.
+func countCardsBig(cards []Scratchcard, beyond beyondPolicy) ([]*big.Int, *big.Int, *big.Int) {
+	copies := make([]*big.Int, len(cards))
+	diff := make([]*big.Int, len(cards)+1)
+	for i := range diff {
+		diff[i] = new(big.Int)
+	}
+
+	one := big.NewInt(1)
+	total := new(big.Int)
+	running := new(big.Int)
+	for i, card := range cards {
+		running.Add(running, diff[i])
+		copies[i] = new(big.Int).Add(running, one)
+		total.Add(total, copies[i])
+
+		count := card.Matches()
+		if count == 0 {
+			continue
+		}
+
+		// Grow the difference array for copies past the end of the table
+		for len(diff) < i+count+2 {
+			diff = append(diff, new(big.Int))
+		}
+		diff[i+1].Add(diff[i+1], copies[i])
+		diff[i+count+1].Sub(diff[i+count+1], copies[i])
+	}
+
+	// Copies of cards past the end of the table
+	past := new(big.Int)
+	for i := len(cards); i < len(diff); i++ {
+		running.Add(running, diff[i])
+		past.Add(past, running)
+	}
+	if beyond == beyondCount {
+		total.Add(total, past)
+	}
+
+	return copies, total, past
 }
 
-// Parse a line of input into the card number, and the two lists of numbers.
-// Return the card number and the two lists of numbers.
-func parseLine(line string) (int, []string, []string) {
+// Read every card from r, one per line, skipping blank lines.  Card numbers
+// must be consecutive (each one more than the one before), since copies are
+// won of the cards that follow by position in the table, and a gap would award
+// copies of the wrong cards.  Errors include the line number.
+func parseCards(r io.Reader, policy duplicatePolicy) ([]Scratchcard, error) {
+	var cards []Scratchcard
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		card, err := parseLine(scanner.Text(), policy)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		if len(cards) > 0 {
+			previous := cards[len(cards)-1].ID
+			if card.ID == previous {
+				return nil, fmt.Errorf("line %d: duplicate card %d", lineNum, card.ID)
+			}
+			if card.ID < previous {
+				return nil, fmt.Errorf("line %d: card %d is out of order (after card %d)", lineNum, card.ID, previous)
+			}
+			if card.ID != previous+1 {
+				return nil, fmt.Errorf("line %d: card %d follows card %d (expected card %d)", lineNum, card.ID, previous, previous+1)
+			}
+		}
+
+		cards = append(cards, card)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cards, nil
+}
+
+// Parse a line of input into a Scratchcard: the "Card" prefix and card number,
+// a colon, and the two lists of numbers separated by a pipe.
+func parseLine(line string, policy duplicatePolicy) (Scratchcard, error) {
 	// Split the line into three parts, using the colon and the pipe characters
-	parts := strings.Split(line, ":")
-	cardStr := strings.TrimLeft(parts[0], "Card ")
-	parts = strings.Split(parts[1], "|")
-	list1 := strings.Fields(parts[0])
-	list2 := strings.Fields(parts[1])
+	header, numbers, found := strings.Cut(line, ":")
+	if !found {
+		return Scratchcard{}, fmt.Errorf("missing ':' after the card number")
+	}
+	winningStr, haveStr, found := strings.Cut(numbers, "|")
+	if !found {
+		return Scratchcard{}, fmt.Errorf("missing '|' between the lists of numbers")
+	}
+	if strings.Contains(haveStr, "|") {
+		return Scratchcard{}, fmt.Errorf("more than one '|'")
+	}
+
+	cardStr, found := strings.CutPrefix(strings.TrimSpace(header), "Card")
+	if !found {
+		return Scratchcard{}, fmt.Errorf("missing \"Card\" prefix: %q", header)
+	}
 
 	// Convert card to an integer
-	card, err := strconv.Atoi(cardStr)
+	card := Scratchcard{Winning: make(map[int]int)}
+	var err error
+	card.ID, err = strconv.Atoi(strings.TrimSpace(cardStr))
+	if err != nil || card.ID < 1 {
+		return Scratchcard{}, fmt.Errorf("invalid card number: %q", strings.TrimSpace(cardStr))
+	}
+
+	winning, err := parseNumbers(winningStr, policy)
 	if err != nil {
-		fmt.Println("Error converting card to integer:", err)
+		return Scratchcard{}, fmt.Errorf("card %d winning numbers: %w", card.ID, err)
+	}
+	card.Have, err = parseNumbers(haveStr, policy)
+	if err != nil {
+		return Scratchcard{}, fmt.Errorf("card %d numbers we have: %w", card.ID, err)
+	}
+
+	for _, num := range winning {
+		card.Winning[num]++
 	}
 
-	return card, list1, list2
+	return card, nil
 }
 
-// Take the card number and the two lists of numbers as arguments and return
-// the count of numbers found in the second list.
-func countMatches(list1 []string, list2 []string) int {
-	count := 0
-	for _, num1 := range list1 {
-		for _, num2 := range list2 {
-			if num1 == num2 {
-				count++
+// Convert a space-separated list of numbers to integers, applying the
+// duplicate policy: with duplicatesOnce, repeats are dropped, and with
+// duplicatesError, they're an error.
+func parseNumbers(list string, policy duplicatePolicy) ([]int, error) {
+	var numbers []int
+	seen := make(map[int]bool)
+
+	for _, numStr := range strings.Fields(list) {
+		num, err := strconv.Atoi(numStr)
+		if err != nil || num < 0 {
+			return nil, fmt.Errorf("invalid number: %q", numStr)
+		}
+
+		if seen[num] {
+			switch policy {
+			case duplicatesOnce:
+				continue
+			case duplicatesError:
+				return nil, fmt.Errorf("duplicate number: %d", num)
 			}
 		}
+		seen[num] = true
+		numbers = append(numbers, num)
 	}
-	return count
+
+	return numbers, nil
+}
+
+// Settings for generateCards.
+type GeneratorConfig struct {
+	Cards        int
+	Winning      int
+	Have         int
+	MaxNumber    int
+	Distribution string
+	Seed         int64
+}
+
+// A GeneratedCards is the text of a random table of cards, along with its
+// expected points and total cards.
+type GeneratedCards struct {
+	Text   []byte
+	Points *big.Int
+	Total  *big.Int
+}
+
+// A matchDistribution picks a random number of matches for a card, from 0 up
+// to limit.
+type matchDistribution func(rng *rand.Rand, limit int) int
+
+// Parse a distribution of matches per card: "uniform" (any number of matches
+// equally likely), "geometric:p" (no matches with probability p, one match
+// with probability (1-p)p, and so on), or "fixed:k" (k matches).  Counts
+// beyond the limit are capped at it.
+func parseMatchDistribution(s string) (matchDistribution, error) {
+	name, arg, _ := strings.Cut(s, ":")
+	switch name {
+	case "uniform":
+		if arg == "" {
+			return func(rng *rand.Rand, limit int) int {
+				return rng.Intn(limit + 1)
+			}, nil
+		}
+	case "geometric":
+		p, err := strconv.ParseFloat(arg, 64)
+		if err == nil && p > 0 && p <= 1 {
+			return func(rng *rand.Rand, limit int) int {
+				count := 0
+				for count < limit && rng.Float64() >= p {
+					count++
+				}
+				return count
+			}, nil
+		}
+	case "fixed":
+		k, err := strconv.Atoi(arg)
+		if err == nil && k >= 0 {
+			return func(rng *rand.Rand, limit int) int {
+				return minInt(k, limit)
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid match distribution: %s (expected uniform, geometric:p or fixed:k)", s)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// generateCards makes a random table of cards.  Each card's number of matches
+// is drawn from the distribution first, capped so that no card wins copies of
+// cards past the end of the table; then its numbers are drawn, without
+// repeats, so that exactly that many of the numbers we have are winning
+// numbers.
+//
+// The expected totals are worked out from the drawn match counts, copying
+// cards one at a time rather than with countCards, so they're independent of
+// both the parser and the difference array.  The same seed always produces the
+// same cards.
+func generateCards(config GeneratorConfig) (GeneratedCards, error) {
+	if config.Cards < 1 || config.Winning < 0 || config.Have < 0 {
+		return GeneratedCards{}, fmt.Errorf("invalid card counts")
+	}
+	if config.Winning+config.Have > config.MaxNumber {
+		return GeneratedCards{}, fmt.Errorf("%d winning numbers and %d numbers we have don't fit in 1 to %d without repeats", config.Winning, config.Have, config.MaxNumber)
+	}
+	distribution, err := parseMatchDistribution(config.Distribution)
+	if err != nil {
+		return GeneratedCards{}, err
+	}
+
+	rng := rand.New(rand.NewSource(config.Seed))
+	cardWidth := len(strconv.Itoa(config.Cards))
+	numberWidth := len(strconv.Itoa(config.MaxNumber))
+
+	matches := make([]int, config.Cards)
+	var text strings.Builder
+	for i := range matches {
+		matches[i] = distribution(rng, minInt(minInt(config.Winning, config.Have), config.Cards-1-i))
+
+		// The first Winning numbers of a shuffle are the winning numbers; we have
+		// the first matches[i] of those, and enough of the rest to make up Have
+		perm := rng.Perm(config.MaxNumber)
+		winning := perm[:config.Winning]
+		have := append(append([]int{}, perm[:matches[i]]...), perm[config.Winning:config.Winning+config.Have-matches[i]]...)
+		rng.Shuffle(len(have), func(a, b int) { have[a], have[b] = have[b], have[a] })
+
+		fmt.Fprintf(&text, "Card %*d:", cardWidth, i+1)
+		for _, num := range winning {
+			fmt.Fprintf(&text, " %*d", numberWidth, num+1)
+		}
+		text.WriteString(" |")
+		for _, num := range have {
+			fmt.Fprintf(&text, " %*d", numberWidth, num+1)
+		}
+		text.WriteString("\n")
+	}
+
+	points := new(big.Int)
+	total := new(big.Int)
+	copies := make([]*big.Int, len(matches))
+	for i := range copies {
+		copies[i] = big.NewInt(1)
+	}
+	for i, count := range matches {
+		if count > 0 {
+			points.Add(points, new(big.Int).Lsh(big.NewInt(1), uint(count-1)))
+		}
+		total.Add(total, copies[i])
+		for j := i + 1; j <= i+count; j++ {
+			copies[j].Add(copies[j], copies[i])
+		}
+	}
+
+	return GeneratedCards{Text: []byte(text.String()), Points: points, Total: total}, nil
+}
+
+// Write a generated table of cards to stdout, and its expected totals (in the
+// same form the solution prints them) to stderr.
+func runGenerate(config GeneratorConfig) error {
+	generated, err := generateCards(config)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stdout.Write(generated.Text); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Points: %s\n", generated.Points)
+	fmt.Fprintf(os.Stderr, "Total: %s\n", generated.Total)
+	return nil
+}
+
+// A Cascade is a table of cards along with the copies counted for each, kept
+// up to date as cards are changed.  The copies of a card only depend on the
+// cards before it, so changing a card only means recounting the cards after
+// it; the copies still being won from earlier cards are seeded into the
+// difference array (see countCards) from the cards within reach, which is at
+// most the largest number of matches seen.
+type Cascade struct {
+	Cards  []Scratchcard
+	Beyond beyondPolicy
+
+	index      map[int]int // card ID to position in Cards
+	matches    []int
+	copies     []*big.Int
+	prefix     []*big.Int // prefix[i] is the copies of the cards before i
+	past       *big.Int   // copies of cards past the end of the table
+	points     *big.Int
+	maxMatches int
+}
+
+// NewCascade counts the copies of each card, with copies won of cards past
+// the end of the table treated according to beyond.
+func NewCascade(cards []Scratchcard, beyond beyondPolicy) *Cascade {
+	c := &Cascade{
+		Cards:   cards,
+		Beyond:  beyond,
+		index:   make(map[int]int, len(cards)),
+		matches: make([]int, len(cards)),
+		copies:  make([]*big.Int, len(cards)),
+		prefix:  make([]*big.Int, len(cards)+1),
+		points:  new(big.Int),
+	}
+	c.prefix[0] = new(big.Int)
+	for i, card := range cards {
+		c.index[card.ID] = i
+		c.matches[i] = card.Matches()
+		c.maxMatches = maxInt(c.maxMatches, c.matches[i])
+		c.points.Add(c.points, card.PointsBig())
+	}
+	c.recount(0)
+	return c
+}
+
+// Total returns the total number of cards.
+func (c *Cascade) Total() *big.Int {
+	total := new(big.Int).Set(c.prefix[len(c.Cards)])
+	if c.Beyond == beyondCount {
+		total.Add(total, c.past)
+	}
+	return total
+}
+
+// Points returns the total points.
+func (c *Cascade) Points() *big.Int {
+	return new(big.Int).Set(c.points)
+}
+
+// Card returns the card with the given ID, its matches and its copies.
+func (c *Cascade) Card(id int) (Scratchcard, int, *big.Int, bool) {
+	i, ok := c.index[id]
+	if !ok {
+		return Scratchcard{}, 0, nil, false
+	}
+	return c.Cards[i], c.matches[i], c.copies[i], true
+}
+
+// Set replaces the card with the same ID, recounting the cards after it.  It
+// returns how many cards were recounted.
+func (c *Cascade) Set(card Scratchcard) (int, error) {
+	i, ok := c.index[card.ID]
+	if !ok {
+		return 0, fmt.Errorf("no card %d", card.ID)
+	}
+
+	matches := card.Matches()
+	if c.Beyond == beyondError && i+matches >= len(c.Cards) {
+		return 0, fmt.Errorf("card %d would win copies of cards past the last card (%d)", card.ID, c.Cards[len(c.Cards)-1].ID)
+	}
+
+	c.points.Sub(c.points, c.Cards[i].PointsBig())
+	c.points.Add(c.points, card.PointsBig())
+	c.Cards[i] = card
+	c.matches[i] = matches
+	c.maxMatches = maxInt(c.maxMatches, matches)
+
+	return c.recount(i + 1), nil
+}
+
+// Recount the copies of the cards from position from onwards, and of cards
+// past the end of the table, returning how many cards were recounted.
+func (c *Cascade) recount(from int) int {
+	n := len(c.Cards)
+	diff := make([]*big.Int, n-from+c.maxMatches+2)
+	for i := range diff {
+		diff[i] = new(big.Int)
+	}
+
+	// Copies still being won from the cards before from
+	for j := maxInt(0, from-c.maxMatches); j < from; j++ {
+		if end := j + c.matches[j]; end >= from {
+			diff[0].Add(diff[0], c.copies[j])
+			diff[end+1-from].Sub(diff[end+1-from], c.copies[j])
+		}
+	}
+
+	one := big.NewInt(1)
+	running := new(big.Int)
+	for i := from; i < n; i++ {
+		running.Add(running, diff[i-from])
+		c.copies[i] = new(big.Int).Add(running, one)
+		c.prefix[i+1] = new(big.Int).Add(c.prefix[i], c.copies[i])
+
+		if count := c.matches[i]; count > 0 {
+			diff[i+1-from].Add(diff[i+1-from], c.copies[i])
+			diff[i+count+1-from].Sub(diff[i+count+1-from], c.copies[i])
+		}
+	}
+
+	c.past = new(big.Int)
+	for i := maxInt(n, from); i-from < len(diff); i++ {
+		running.Add(running, diff[i-from])
+		c.past.Add(c.past, running)
+	}
+
+	return n - from
+}
+
+// Read what-if commands from r, one per line, writing the results to w.
+// Mistakes in a command are reported and the command ignored.
+func runWhatIf(cascade *Cascade, policy duplicatePolicy, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		command, args, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		args = strings.TrimSpace(args)
+
+		switch command {
+		case "":
+		case "set":
+			idStr, numbers, _ := strings.Cut(args, " ")
+			card, err := parseLine("Card "+idStr+":"+numbers, policy)
+			if err == nil {
+				var recounted int
+				recounted, err = cascade.Set(card)
+				if err == nil {
+					fmt.Fprintf(w, "Card %d: Matches: %d, Points: %s (recounted %d cards)\n", card.ID, card.Matches(), card.PointsBig(), recounted)
+				}
+			}
+			if err != nil {
+				fmt.Fprintln(w, "Error:", err)
+			}
+		case "card":
+			id, err := strconv.Atoi(args)
+			card, matches, copies, ok := cascade.Card(id)
+			if err != nil || !ok {
+				fmt.Fprintf(w, "Error: no card %q\n", args)
+				continue
+			}
+			fmt.Fprintf(w, "Card %d: Matches: %d, Copies: %s\n", card.ID, matches, copies)
+		case "total":
+			fmt.Fprintf(w, "Total: %s\n", cascade.Total())
+		case "points":
+			fmt.Fprintf(w, "Points: %s\n", cascade.Points())
+		case "quit":
+			return nil
+		default:
+			fmt.Fprintf(w, "Error: unknown command %q (expected set, card, total, points or quit)\n", command)
+		}
+	}
+
+	return scanner.Err()
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func readCards(t *testing.T, filename string) []Scratchcard {
+	t.Helper()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cards, err := parseCards(f, duplicatesMultiply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cards
+}
+
+// The sample from INSTRUCTIONS.md: 13 points, and 30 cards.
+func TestSampleInput(t *testing.T) {
+	cards := readCards(t, "sample-input.txt")
+
+	points, err := countPoints(cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points != 13 {
+		t.Errorf("points = %d, want 13", points)
+	}
+
+	copies, total, _, err := countCards(cards, beyondCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 30 {
+		t.Errorf("total cards = %d, want 30", total)
+	}
+	want := []int{1, 2, 4, 8, 14, 1}
+	for i := range want {
+		if copies[i] != want[i] {
+			t.Errorf("card %d copies = %d, want %d", cards[i].ID, copies[i], want[i])
+		}
+	}
+}
+
+// A card with 64 matches is worth 2^63 points, which doesn't fit in an int.
+func TestPointsOverflow(t *testing.T) {
+	var winning, have []string
+	for i := 1; i <= 64; i++ {
+		winning = append(winning, fmt.Sprint(i))
+		have = append(have, fmt.Sprint(i))
+	}
+	line := "Card 1: " + strings.Join(winning, " ") + " | " + strings.Join(have, " ")
+	card, err := parseLine(line, duplicatesMultiply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := card.Points(); err != errOverflow {
+		t.Errorf("Points() error = %v, want errOverflow", err)
+	}
+	if _, err := countPoints([]Scratchcard{card}); err != errOverflow {
+		t.Errorf("countPoints error = %v, want errOverflow", err)
+	}
+
+	want := new(big.Int).Lsh(big.NewInt(1), 63)
+	if got := countPointsBig([]Scratchcard{card}); got.Cmp(want) != 0 {
+		t.Errorf("countPointsBig = %s, want %s", got, want)
+	}
+}
+
+// overflow-input.txt has far more cards than fit in an int, none of them past
+// the end of the table.
+func TestCardCountOverflow(t *testing.T) {
+	cards := readCards(t, "overflow-input.txt")
+
+	if err := checkBeyond(cards); err != nil {
+		t.Error(err)
+	}
+
+	if _, _, _, err := countCards(cards, beyondCount); err != errOverflow {
+		t.Errorf("countCards error = %v, want errOverflow", err)
+	}
+
+	want, _ := new(big.Int).SetString("1214081766856297026980371898358", 10)
+	_, total, past := countCardsBig(cards, beyondCount)
+	if total.Cmp(want) != 0 {
+		t.Errorf("countCardsBig total = %s, want %s", total, want)
+	}
+	if past.Sign() != 0 {
+		t.Errorf("countCardsBig copies past the last card = %s, want 0", past)
+	}
+}
+
+// Malformed tables are rejected with the line number of the problem.
+func TestParseCardsErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Card 1: 1 2 | 3\nCard 3: 1 | 1\n", "line 2: card 3 follows card 1 (expected card 2)"},
+		{"Card 2: 1 | 1\nCard 1: 1 | 1\n", "line 2: card 1 is out of order (after card 2)"},
+		{"Card 1: 1 | 1\n\nCard 1: 1 | 1\n", "line 3: duplicate card 1"},
+		{"Card -3: 1 | 1\n", `line 1: invalid card number: "-3"`},
+		{"Card 0: 1 | 1\n", `line 1: invalid card number: "0"`},
+		{"Card 1: 1 2 3\n", "line 1: missing '|' between the lists of numbers"},
+		{"Card 1 1 | 2\n", "line 1: missing ':' after the card number"},
+		{"Crd 1: 1 | 2\n", `line 1: missing "Card" prefix: "Crd 1"`},
+		{"Card 1: 1 x | 2\n", `line 1: card 1 winning numbers: invalid number: "x"`},
+	}
+
+	for _, test := range tests {
+		_, err := parseCards(strings.NewReader(test.input), duplicatesMultiply)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("parseCards(%q) error = %v, want %q", test.input, err, test.want)
+		}
+	}
+}
+
+// Cascade.Set only recounts the cards after the changed one, seeding the
+// copies still arriving from the cards before it.  After every Set, the
+// totals must match a full recount.
+func TestCascadeSet(t *testing.T) {
+	for _, beyond := range []beyondPolicy{beyondCount, beyondClip} {
+		for seed := int64(1); seed <= 200; seed++ {
+			config := GeneratorConfig{Cards: 40, Winning: 10, Have: 25, MaxNumber: 99, Distribution: "geometric:0.3", Seed: seed}
+			generated, err := generateCards(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cards, err := parseCards(bytes.NewReader(generated.Text), duplicatesMultiply)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cascade := NewCascade(cards, beyond)
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 20; i++ {
+				// A random card with up to 10 matches, which may reach past
+				// the end of the table
+				card := Scratchcard{ID: 1 + rng.Intn(len(cards)), Winning: make(map[int]int)}
+				matches := rng.Intn(11)
+				for num := 1; num <= 10; num++ {
+					card.Winning[num] = 1
+				}
+				for num := 1; num <= matches; num++ {
+					card.Have = append(card.Have, num)
+				}
+				if _, err := cascade.Set(card); err != nil {
+					t.Fatal(err)
+				}
+
+				copies, total, _ := countCardsBig(cascade.Cards, beyond)
+				if got := cascade.Total(); got.Cmp(total) != 0 {
+					t.Fatalf("policy %d, seed %d, set %d: Total = %s, want %s", beyond, seed, i, got, total)
+				}
+				if got, want := cascade.Points(), countPointsBig(cascade.Cards); got.Cmp(want) != 0 {
+					t.Fatalf("policy %d, seed %d, set %d: Points = %s, want %s", beyond, seed, i, got, want)
+				}
+				for j, c := range cascade.Cards {
+					if _, _, got, _ := cascade.Card(c.ID); got.Cmp(copies[j]) != 0 {
+						t.Fatalf("policy %d, seed %d, set %d: card %d copies = %s, want %s", beyond, seed, i, c.ID, got, copies[j])
+					}
+				}
+			}
+		}
+	}
+}
@@ -82,13 +82,28 @@
 
 // What is the lowest location number that corresponds to any of the initial seed numbers?
 
+// The seeds line actually describes ranges of seeds, in pairs of a start and a
+// length, and the ranges cover billions of seeds.  Rather than converting each
+// seed, whole ranges are converted at once: a range is split wherever it
+// crosses the edge of a conversion map's source range, and each piece is
+// shifted (or passed through) as a unit.  The lowest location is then the
+// lowest start of the resulting location ranges, found in time proportional
+// to the number of ranges and map entries rather than the number of seeds.
+
+// The maps aren't fixed to the seven categories above: each "X-to-Y map:"
+// header adds a conversion from category X to category Y, and the chain of
+// maps from seed to the category given by -to (location by default) is worked
+// out from them.  It's an error if there's no such chain, or more than one.
+
 package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -100,16 +115,79 @@ type ConversionMap struct {
 	RangeLength           int
 }
 
-// Create a struct containing an array of ConversionMap structs
-// for each type of conversion (seed-to-soil, soil-to-fertilizer, etc.)
-type ConversionMaps struct {
-	SeedToSoilMap         []ConversionMap
-	SoilToFertilizerMap   []ConversionMap
-	FertilizerToWaterMap  []ConversionMap
-	WaterToLightMap       []ConversionMap
-	LightToTemperatureMap []ConversionMap
-	TemperatureToHumidity []ConversionMap
-	HumidityToLocationMap []ConversionMap
+// Struct containing the array of ConversionMap structs for one type of
+// conversion (seed-to-soil, soil-to-fertilizer, etc.), from the source
+// category to the destination category.
+type CategoryMap struct {
+	Source         string
+	Destination    string
+	ConversionMaps []ConversionMap
+}
+
+// Name returns the name of the map, as in its header ("seed-to-soil").
+func (m *CategoryMap) Name() string {
+	return m.Source + "-to-" + m.Destination
+}
+
+// Struct containing every CategoryMap in the almanac, in the order they
+// appear.
+type Almanac struct {
+	Maps []*CategoryMap
+}
+
+// Function that finds the chain of maps that converts numbers in the from
+// category to numbers in the to category, following the maps from each
+// source category to its destination.  It's an error if there's no such
+// chain, or if there's more than one.
+
+func (a *Almanac) Chain(from string, to string) ([]*CategoryMap, error) {
+	var chains [][]*CategoryMap
+	reached := map[string]bool{}
+
+	// Walk every path of maps out of the from category, without visiting a
+	// category twice, keeping the ones that end at the to category
+	visited := map[string]bool{from: true}
+	var walk func(category string, chain []*CategoryMap)
+	walk = func(category string, chain []*CategoryMap) {
+		if category == to {
+			chains = append(chains, append([]*CategoryMap{}, chain...))
+			return
+		}
+		for _, m := range a.Maps {
+			if m.Source == category && !visited[m.Destination] {
+				reached[m.Destination] = true
+				visited[m.Destination] = true
+				walk(m.Destination, append(chain, m))
+				visited[m.Destination] = false
+			}
+		}
+	}
+	walk(from, nil)
+
+	switch len(chains) {
+	case 0:
+		var categories []string
+		for category := range reached {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		if len(categories) == 0 {
+			return nil, fmt.Errorf("no chain of maps from %s to %s (there are no maps from %s)", from, to, from)
+		}
+		return nil, fmt.Errorf("no chain of maps from %s to %s (%s reaches %s)", from, to, from, strings.Join(categories, ", "))
+	case 1:
+		return chains[0], nil
+	}
+
+	var names []string
+	for _, chain := range chains {
+		var steps []string
+		for _, m := range chain {
+			steps = append(steps, m.Name())
+		}
+		names = append(names, strings.Join(steps, ", "))
+	}
+	return nil, fmt.Errorf("%d chains of maps from %s to %s: %s", len(chains), from, to, strings.Join(names, "; "))
 }
 
 // Main function that opens the input file (specified on the command line),
@@ -117,8 +195,16 @@ type ConversionMaps struct {
 // calls another function for reading the conversion maps, until the end of the file
 
 func main() {
+	to := flag.String("to", "location", "category to convert the seeds to")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: solution [-to <category>] <input file>")
+		os.Exit(1)
+	}
+
 	// Read the input file, and check for errors
-	file, err := os.Open(os.Args[1])
+	file, err := os.Open(flag.Arg(0))
 	if err != nil {
 		panic(err)
 	}
@@ -130,94 +216,111 @@ func main() {
 
 	fmt.Printf("%v\n", seedRanges)
 
-	// Read the conversion maps
-	conversionMaps := readConversionMaps(scanner)
+	// Read the conversion maps, and find the chain of them from seed to the
+	// requested category
+	almanac, err := readConversionMaps(scanner)
+	if err != nil {
+		fmt.Println("Error reading maps:", err)
+		os.Exit(1)
+	}
+	chain, err := almanac.Chain("seed", *to)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 
-	// Print out the contents of each element of the conversionMaps struct
-	fmt.Printf("%v\n", conversionMaps.SeedToSoilMap)
-	fmt.Printf("%v\n", conversionMaps.SoilToFertilizerMap)
-	fmt.Printf("%v\n", conversionMaps.FertilizerToWaterMap)
-	fmt.Printf("%v\n", conversionMaps.WaterToLightMap)
-	fmt.Printf("%v\n", conversionMaps.LightToTemperatureMap)
-	fmt.Printf("%v\n", conversionMaps.TemperatureToHumidity)
-	fmt.Printf("%v\n", conversionMaps.HumidityToLocationMap)
+	// Print out the contents of each map in the chain
+	for _, categoryMap := range chain {
+		fmt.Printf("%s: %v\n", categoryMap.Name(), categoryMap.ConversionMaps)
+	}
 
-	lowestLocationNumber := -1
-	// Loop through the seed ranges, producing a one-dimensional array of seed numbers
+	// Convert the seed ranges to location ranges
 	for _, seedRange := range seedRanges {
 		fmt.Printf("%v (%d)\n", seedRange, seedRange[1]-seedRange[0]+1)
-		for i := seedRange[0]; i <= seedRange[1]; i++ {
-			locationNumber := calculateLocationNumber(i, conversionMaps)
-
-			if lowestLocationNumber == -1 || locationNumber < lowestLocationNumber {
-				lowestLocationNumber = locationNumber
-			}
-		}
 	}
+	locationRanges := calculateLocationRanges(seedRanges, chain)
 
 	// Calculate the lowest location number
+	lowestLocationNumber := -1
+	for _, locationRange := range locationRanges {
+		if lowestLocationNumber == -1 || locationRange[0] < lowestLocationNumber {
+			lowestLocationNumber = locationRange[0]
+		}
+	}
 
 	// Print the lowest location number
 	fmt.Println(lowestLocationNumber)
 
 }
 
-// Function that takes a number (source) and an array of ConversionMap structs,
-// checking convertNumber for each conversion map, returning the resulting
-// destination number if the source number is found in the conversion map.
-// If the source number is not found in any conversion map, the function
-// returns the source number.
+// Function that takes an array of seed ranges and a chain of maps, and
+// returns the ranges of numbers they correspond to at the end of the chain.
 
-func convertNumberArray(source int, conversionMaps []ConversionMap) int {
-	// Loop through the conversion maps
-	for _, conversionMap := range conversionMaps {
-		// Check the conversion map for the source number
-		destination := convertNumber(source, conversionMap.SourceRangeStart, conversionMap.DestinationRangeStart, conversionMap.RangeLength)
+func calculateLocationRanges(seedRanges [][]int, chain []*CategoryMap) [][]int {
+	// Convert the seed ranges through each map in turn
+	locationRanges := seedRanges
+	for _, categoryMap := range chain {
+		locationRanges = convertRangeArray(locationRanges, categoryMap.ConversionMaps)
+	}
+
+	// Return the location ranges
+	return locationRanges
+}
 
-		// If the source number is found in the conversion map, return the destination number
-		if destination != source {
-			return destination
+// Function that takes an array of ranges of source numbers ([start, end],
+// inclusive) and an array of ConversionMap structs, and returns the ranges of
+// destination numbers they correspond to.  The part of a range inside a
+// conversion map's source range is shifted to its destination range; the
+// parts outside it are checked against the remaining conversion maps, and
+// whatever isn't in any of them is returned unchanged.
+
+func convertRangeArray(sources [][]int, conversionMaps []ConversionMap) [][]int {
+	var destinations [][]int
+
+	// Loop through the conversion maps, keeping the parts of the ranges
+	// that haven't been converted yet
+	var pending [][]int
+	for _, source := range sources {
+		if source[0] <= source[1] {
+			pending = append(pending, source)
 		}
 	}
+	for _, conversionMap := range conversionMaps {
+		// A conversion map with no numbers in it doesn't convert anything
+		if conversionMap.RangeLength <= 0 {
+			continue
+		}
 
-	// If the source number is not found in any conversion map, return the source number
-	return source
-}
+		mapStart := conversionMap.SourceRangeStart
+		mapEnd := conversionMap.SourceRangeStart + conversionMap.RangeLength - 1
+		shift := conversionMap.DestinationRangeStart - conversionMap.SourceRangeStart
 
-// Function that takes an array of seed numbers and a ConversionMaps struct,
-// and returns the lowest location number that corresponds to any of the seed numbers.
-
-func calculateLocationNumber(seedNumber int, conversionMaps *ConversionMaps) int {
-	// Convert the seed number to a location number
-	locationNumber := convertNumberArray(seedNumber, conversionMaps.SeedToSoilMap)
-	locationNumber = convertNumberArray(locationNumber, conversionMaps.SoilToFertilizerMap)
-	locationNumber = convertNumberArray(locationNumber, conversionMaps.FertilizerToWaterMap)
-	locationNumber = convertNumberArray(locationNumber, conversionMaps.WaterToLightMap)
-	locationNumber = convertNumberArray(locationNumber, conversionMaps.LightToTemperatureMap)
-	locationNumber = convertNumberArray(locationNumber, conversionMaps.TemperatureToHumidity)
-	locationNumber = convertNumberArray(locationNumber, conversionMaps.HumidityToLocationMap)
-
-	// Return the lowest location number
-	return locationNumber
-}
+		var unconverted [][]int
+		for _, source := range pending {
+			// If the range doesn't overlap the conversion map, keep it for the next one
+			if source[1] < mapStart || source[0] > mapEnd {
+				unconverted = append(unconverted, source)
+				continue
+			}
 
-// Function that takes a number (source), source range start number,
-// destination range start number, and range length, and returns the
-// corresponding number (destination) in the destination range.  If
-// the source number is not in the source range, the function returns
-// the source number.
+			// Keep the parts before and after the conversion map for the next one
+			if source[0] < mapStart {
+				unconverted = append(unconverted, []int{source[0], mapStart - 1})
+			}
+			if source[1] > mapEnd {
+				unconverted = append(unconverted, []int{mapEnd + 1, source[1]})
+			}
 
-func convertNumber(source int, sourceRangeStart int, destinationRangeStart int, rangeLength int) int {
-	// If the source number is not in the source range, return the source number
-	if source < sourceRangeStart || source >= sourceRangeStart+rangeLength {
-		return source
+			// Shift the overlapping part to the destination range
+			start := max(source[0], mapStart)
+			end := min(source[1], mapEnd)
+			destinations = append(destinations, []int{start + shift, end + shift})
+		}
+		pending = unconverted
 	}
 
-	// Calculate the destination number
-	destination := destinationRangeStart + (source - sourceRangeStart)
-
-	// Return the destination number
-	return destination
+	// Any ranges not found in a conversion map correspond to the same numbers
+	return append(destinations, pending...)
 }
 
 // Function for reading the first line of seed numbers,
@@ -242,9 +345,12 @@ func readSeedRanges(scanner *bufio.Scanner) [][]int {
 		// If there are at least two more words, convert them to integers and add them as an int array to the array
 		if i+1 < len(words) {
 			start, _ := strconv.Atoi(words[i])
-			end, _ := strconv.Atoi(words[i+1])
-			end += start - 1
-			seedRanges = append(seedRanges, []int{start, end})
+			length, _ := strconv.Atoi(words[i+1])
+
+			// A range with no seeds in it is left out
+			if length > 0 {
+				seedRanges = append(seedRanges, []int{start, start + length - 1})
+			}
 		}
 	}
 
@@ -253,61 +359,57 @@ func readSeedRanges(scanner *bufio.Scanner) [][]int {
 }
 
 // Function for reading the conversion maps,
-// and returning a ConversionMaps struct
+// and returning an Almanac struct
 // The format of the maps looks like this:
 // seed-to-soil map:
 // 50 98 2
 // 52 50 48
+// Any "X-to-Y map:" header starts a map from category X to category Y.  It's
+// an error for the same map to appear twice, or for numbers to come before
+// the first header.
 
-func readConversionMaps(scanner *bufio.Scanner) *ConversionMaps {
-	// Create an array of conversion map
-	var conversionMap ConversionMap
-
-	// Create an empty conversionMaps struct
-	var conversionMaps ConversionMaps
+func readConversionMaps(scanner *bufio.Scanner) (*Almanac, error) {
+	// Create an empty almanac
+	almanac := &Almanac{}
+	headerRegexp := regexp.MustCompile(`^(\w+)-to-(\w+) map:$`)
 
-	var mapName string
+	var categoryMap *CategoryMap
 
 	// Read the file line by line
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		// Check if the line matches a regular expression of the form "word-to-word map:"
-		if words := regexp.MustCompile(`^(\w+-to-\w+) map:$`).FindStringSubmatch(line); words != nil {
-			mapName = words[1]
+		// Check if the line matches a regular expression of the form "word-to-word map:",
+		// and start a new map if it does
+		if words := headerRegexp.FindStringSubmatch(line); words != nil {
+			categoryMap = &CategoryMap{Source: words[1], Destination: words[2]}
+			for _, m := range almanac.Maps {
+				if m.Name() == categoryMap.Name() {
+					return nil, fmt.Errorf("duplicate %s map", categoryMap.Name())
+				}
+			}
+			almanac.Maps = append(almanac.Maps, categoryMap)
 		}
 
 		// If the line starts with a number, split the line into an array of strings
-		// and convert the strings to integers, then add them to the conversion map
+		// and convert the strings to integers, then add them to the current map
 		if line != "" && isDigit(line[0]) {
+			if categoryMap == nil {
+				return nil, fmt.Errorf("numbers before the first map header: %q", line)
+			}
+
+			var conversionMap ConversionMap
 			words := strings.Split(line, " ")
 			conversionMap.DestinationRangeStart, _ = strconv.Atoi(words[0])
 			conversionMap.SourceRangeStart, _ = strconv.Atoi(words[1])
 			conversionMap.RangeLength, _ = strconv.Atoi(words[2])
 
-			// Determine which element of the conversionMaps struct to add the conversion map to,
-			// based on the mapName string
-			switch mapName {
-			case "seed-to-soil":
-				conversionMaps.SeedToSoilMap = append(conversionMaps.SeedToSoilMap, conversionMap)
-			case "soil-to-fertilizer":
-				conversionMaps.SoilToFertilizerMap = append(conversionMaps.SoilToFertilizerMap, conversionMap)
-			case "fertilizer-to-water":
-				conversionMaps.FertilizerToWaterMap = append(conversionMaps.FertilizerToWaterMap, conversionMap)
-			case "water-to-light":
-				conversionMaps.WaterToLightMap = append(conversionMaps.WaterToLightMap, conversionMap)
-			case "light-to-temperature":
-				conversionMaps.LightToTemperatureMap = append(conversionMaps.LightToTemperatureMap, conversionMap)
-			case "temperature-to-humidity":
-				conversionMaps.TemperatureToHumidity = append(conversionMaps.TemperatureToHumidity, conversionMap)
-			case "humidity-to-location":
-				conversionMaps.HumidityToLocationMap = append(conversionMaps.HumidityToLocationMap, conversionMap)
-			}
+			categoryMap.ConversionMaps = append(categoryMap.ConversionMaps, conversionMap)
 		}
 	}
 
-	// Return the array of conversion maps
-	return &conversionMaps
+	// Return the almanac
+	return almanac, scanner.Err()
 }
 
 // As isDigit function for checking if a character is a digit
@@ -0,0 +1,3 @@
+module github.com/misterdorm/aoc-2023/grid
+
+go 1.21
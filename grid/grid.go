@@ -0,0 +1,353 @@
+// Package grid is a generic two-dimensional grid, for puzzles whose input is
+// a block of text with one cell per character.
+package grid
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A Point is a position in a grid, by row and column.
+type Point struct {
+	Row int
+	Col int
+}
+
+// Add returns the point offset from p by q.
+func (p Point) Add(q Point) Point {
+	return Point{Row: p.Row + q.Row, Col: p.Col + q.Col}
+}
+
+// Less reports whether p comes before q in row-major (reading) order.
+func (p Point) Less(q Point) bool {
+	return p.Row < q.Row || p.Row == q.Row && p.Col < q.Col
+}
+
+// Offsets of the 4 neighbors (no diagonals) and 8 neighbors of a point.
+var (
+	Offsets4 = []Point{{-1, 0}, {0, -1}, {0, 1}, {1, 0}}
+	Offsets8 = []Point{{-1, -1}, {-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, -1}, {1, 0}, {1, 1}}
+)
+
+// A Neighborhood is the set of cells within a distance (Radius) of a cell,
+// measured with either the chebyshev metric (a square, including diagonals) or
+// the manhattan metric (a diamond).  A radius of 1 gives the usual 8 or 4
+// neighbors.
+type Neighborhood struct {
+	Metric string
+	Radius int
+}
+
+// ParseNeighborhood parses a neighborhood given as "4" or "8" (the 4- or
+// 8-connected neighbors), or as "chebyshev:k" or "manhattan:k".
+func ParseNeighborhood(s string) (Neighborhood, error) {
+	switch s {
+	case "4":
+		return Neighborhood{Metric: "manhattan", Radius: 1}, nil
+	case "8":
+		return Neighborhood{Metric: "chebyshev", Radius: 1}, nil
+	}
+
+	metric, radius, found := strings.Cut(s, ":")
+	k, err := strconv.Atoi(radius)
+	if !found || err != nil || k < 1 || (metric != "chebyshev" && metric != "manhattan") {
+		return Neighborhood{}, fmt.Errorf("invalid neighborhood: %s (expected 4, 8, chebyshev:k or manhattan:k)", s)
+	}
+
+	return Neighborhood{Metric: metric, Radius: k}, nil
+}
+
+// Offsets returns the offsets of every cell in the neighborhood, other than
+// the center, row by row.
+func (n Neighborhood) Offsets() []Point {
+	var offsets []Point
+	for dr := -n.Radius; dr <= n.Radius; dr++ {
+		for dc := -n.Radius; dc <= n.Radius; dc++ {
+			if dr == 0 && dc == 0 {
+				continue
+			}
+			if n.Metric == "manhattan" && absInt(dr)+absInt(dc) > n.Radius {
+				continue
+			}
+			offsets = append(offsets, Point{dr, dc})
+		}
+	}
+	return offsets
+}
+
+// A Grid is a rectangular grid of cells of type T, stored row by row.
+type Grid[T any] struct {
+	width  int
+	height int
+	cells  []T
+}
+
+// NewGrid returns a grid of the given size with every cell set to the zero
+// value of T.
+func NewGrid[T any](width int, height int) *Grid[T] {
+	return &Grid[T]{width: width, height: height, cells: make([]T, width*height)}
+}
+
+// FromRows returns a grid holding the given rows.  The grid is as wide as the
+// longest row; shorter rows are padded with fill.
+func FromRows[T any](rows [][]T, fill T) *Grid[T] {
+	width := 0
+	for _, row := range rows {
+		width = maxInt(width, len(row))
+	}
+
+	grid := NewGrid[T](width, len(rows))
+	for r, row := range rows {
+		for c := 0; c < width; c++ {
+			if c < len(row) {
+				grid.cells[r*width+c] = row[c]
+			} else {
+				grid.cells[r*width+c] = fill
+			}
+		}
+	}
+	return grid
+}
+
+// The longest line NewScanner (and so Parse and Read) can read.
+// bufio.Scanner's default limit is 64 KiB, which is too short for some
+// puzzle-sized inputs.
+const MaxLineLength = 1 << 30
+
+// NewScanner returns a scanner that splits r into lines, allowing lines of up
+// to MaxLineLength bytes.  Unlike bufio.ScanLines, a \r at the end of a line
+// is kept, so that callers can report Windows line endings if they want to.
+func NewScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MaxLineLength)
+	scanner.Split(scanRawLines)
+	return scanner
+}
+
+// A bufio.SplitFunc like bufio.ScanLines, except that a \r at the end of a
+// line is kept.
+func scanRawLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// ReadOptions control how Read turns text into a grid.
+type ReadOptions struct {
+	// Line, if set, is called with each line as it's read (including any \r
+	// ending) and returns the row to use for it, or an error to stop reading.
+	// Without it, a \r ending is dropped and the line is used as it is.
+	Line func(line []byte) ([]byte, error)
+
+	// Pad pads rows shorter than the longest row with Fill, rather than
+	// failing when the rows aren't all the same length.
+	Pad  bool
+	Fill byte
+}
+
+// Parse reads a grid of characters from r, one line per row.  Every row must
+// be the same length.  Windows line endings and empty lines at the end are
+// ignored.
+func Parse(r io.Reader) (*Grid[byte], error) {
+	return Read(r, ReadOptions{})
+}
+
+// Read reads a grid of characters from r, one line per row, as Parse does but
+// with options.  Empty lines at the end are never rows.  Rows that aren't the
+// same length as the first are only checked for once every line has been
+// read, so that a Line function sees (and can report on) every line first.
+func Read(r io.Reader, options ReadOptions) (*Grid[byte], error) {
+	var rows [][]byte
+
+	scanner := NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if options.Line != nil {
+			var err error
+			if line, err = options.Line(line); err != nil {
+				return nil, err
+			}
+		} else {
+			line = bytes.TrimSuffix(line, []byte("\r"))
+		}
+
+		rows = append(rows, append([]byte(nil), line...))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for len(rows) > 0 && len(rows[len(rows)-1]) == 0 {
+		rows = rows[:len(rows)-1]
+	}
+
+	if !options.Pad {
+		for r, row := range rows {
+			if len(row) != len(rows[0]) {
+				return nil, fmt.Errorf("line %d: length %d, expected %d", r+1, len(row), len(rows[0]))
+			}
+		}
+	}
+
+	return FromRows(rows, options.Fill), nil
+}
+
+// Width returns the number of columns in the grid.
+func (g *Grid[T]) Width() int {
+	return g.width
+}
+
+// Height returns the number of rows in the grid.
+func (g *Grid[T]) Height() int {
+	return g.height
+}
+
+// InBounds reports whether p is inside the grid.
+func (g *Grid[T]) InBounds(p Point) bool {
+	return p.Row >= 0 && p.Row < g.height && p.Col >= 0 && p.Col < g.width
+}
+
+// At returns the cell at p.  If p is outside the grid, it returns the zero
+// value of T and false.
+func (g *Grid[T]) At(p Point) (T, bool) {
+	if !g.InBounds(p) {
+		var zero T
+		return zero, false
+	}
+	return g.cells[p.Row*g.width+p.Col], true
+}
+
+// Set sets the cell at p, returning false (and doing nothing) if p is outside
+// the grid.
+func (g *Grid[T]) Set(p Point, value T) bool {
+	if !g.InBounds(p) {
+		return false
+	}
+	g.cells[p.Row*g.width+p.Col] = value
+	return true
+}
+
+// Neighbors returns the points at the given offsets from p that are inside
+// the grid.
+func (g *Grid[T]) Neighbors(p Point, offsets []Point) []Point {
+	var neighbors []Point
+	for _, offset := range offsets {
+		if n := p.Add(offset); g.InBounds(n) {
+			neighbors = append(neighbors, n)
+		}
+	}
+	return neighbors
+}
+
+// Neighbors4 returns the points above, below, left and right of p that are
+// inside the grid.
+func (g *Grid[T]) Neighbors4(p Point) []Point {
+	return g.Neighbors(p, Offsets4)
+}
+
+// Neighbors8 returns the points around p, including diagonals, that are
+// inside the grid.
+func (g *Grid[T]) Neighbors8(p Point) []Point {
+	return g.Neighbors(p, Offsets8)
+}
+
+// Row returns a copy of the cells in row r, or nil if r is outside the grid.
+func (g *Grid[T]) Row(r int) []T {
+	if r < 0 || r >= g.height {
+		return nil
+	}
+	return append([]T(nil), g.cells[r*g.width:(r+1)*g.width]...)
+}
+
+// Col returns a copy of the cells in column c, or nil if c is outside the grid.
+func (g *Grid[T]) Col(c int) []T {
+	if c < 0 || c >= g.width {
+		return nil
+	}
+	col := make([]T, g.height)
+	for r := range col {
+		col[r] = g.cells[r*g.width+c]
+	}
+	return col
+}
+
+// Scan calls fn for every cell in the grid, row by row.
+func (g *Grid[T]) Scan(fn func(p Point, value T)) {
+	g.Region(Point{0, 0}, Point{g.height - 1, g.width - 1}, fn)
+}
+
+// Region calls fn, row by row, for every cell in the rectangle with corners
+// min and max (inclusive), clipped to the grid.
+func (g *Grid[T]) Region(min Point, max Point, fn func(p Point, value T)) {
+	for r := maxInt(min.Row, 0); r <= max.Row && r < g.height; r++ {
+		for c := maxInt(min.Col, 0); c <= max.Col && c < g.width; c++ {
+			fn(Point{r, c}, g.cells[r*g.width+c])
+		}
+	}
+}
+
+// A Token is a horizontal run of matching cells, such as the digits of a
+// multi-digit number.
+type Token[T any] struct {
+	Start Point
+	Cells []T
+}
+
+// End returns the position of the last cell of the token.
+func (t Token[T]) End() Point {
+	return Point{Row: t.Start.Row, Col: t.Start.Col + len(t.Cells) - 1}
+}
+
+// Points returns the positions of every cell of the token.
+func (t Token[T]) Points() []Point {
+	points := make([]Point, len(t.Cells))
+	for i := range points {
+		points[i] = Point{Row: t.Start.Row, Col: t.Start.Col + i}
+	}
+	return points
+}
+
+// Tokens returns every maximal horizontal run of cells for which match
+// returns true, row by row.  Runs never continue from one row to the next.
+func (g *Grid[T]) Tokens(match func(T) bool) []Token[T] {
+	var tokens []Token[T]
+	for r := 0; r < g.height; r++ {
+		for c := 0; c < g.width; c++ {
+			if !match(g.cells[r*g.width+c]) {
+				continue
+			}
+			token := Token[T]{Start: Point{r, c}}
+			for ; c < g.width && match(g.cells[r*g.width+c]); c++ {
+				token.Cells = append(token.Cells, g.cells[r*g.width+c])
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,149 @@
+package grid
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, text string) *Grid[byte] {
+	t.Helper()
+
+	g, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestParse(t *testing.T) {
+	g := parse(t, "abc\r\ndef\n\n")
+	if g.Width() != 3 || g.Height() != 2 {
+		t.Fatalf("size = %dx%d, want 3x2", g.Width(), g.Height())
+	}
+	if got := string(g.Row(1)); got != "def" {
+		t.Errorf("Row(1) = %q, want \"def\"", got)
+	}
+
+	if _, err := Parse(strings.NewReader("abc\nde\n")); err == nil || err.Error() != "line 2: length 2, expected 3" {
+		t.Errorf("ragged rows: error = %v", err)
+	}
+	if _, err := Parse(strings.NewReader("abc\n\ndef\n")); err == nil || err.Error() != "line 2: length 0, expected 3" {
+		t.Errorf("empty row: error = %v", err)
+	}
+
+	g, err := Read(strings.NewReader("abc\nd\n"), ReadOptions{Pad: true, Fill: '.'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(g.Row(1)); got != "d.." {
+		t.Errorf("padded Row(1) = %q, want \"d..\"", got)
+	}
+
+	empty := parse(t, "")
+	if empty.Width() != 0 || empty.Height() != 0 {
+		t.Errorf("empty size = %dx%d, want 0x0", empty.Width(), empty.Height())
+	}
+}
+
+func TestAtEdges(t *testing.T) {
+	g := parse(t, "ab\ncd\n")
+
+	tests := []struct {
+		p    Point
+		want byte
+		ok   bool
+	}{
+		{Point{0, 0}, 'a', true},
+		{Point{1, 1}, 'd', true},
+		{Point{-1, 0}, 0, false},
+		{Point{0, -1}, 0, false},
+		{Point{2, 0}, 0, false},
+		{Point{0, 2}, 0, false},
+	}
+	for _, test := range tests {
+		got, ok := g.At(test.p)
+		if got != test.want || ok != test.ok {
+			t.Errorf("At(%v) = %q, %v, want %q, %v", test.p, got, ok, test.want, test.ok)
+		}
+		if g.InBounds(test.p) != test.ok {
+			t.Errorf("InBounds(%v) = %v, want %v", test.p, !test.ok, test.ok)
+		}
+	}
+
+	if g.Set(Point{2, 2}, 'x') {
+		t.Error("Set outside the grid returned true")
+	}
+}
+
+func TestNeighborsAtCorners(t *testing.T) {
+	g := NewGrid[byte](3, 3)
+
+	if got, want := g.Neighbors4(Point{0, 0}), []Point{{0, 1}, {1, 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Neighbors4 of top left = %v, want %v", got, want)
+	}
+	if got, want := g.Neighbors8(Point{0, 0}), []Point{{0, 1}, {1, 0}, {1, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Neighbors8 of top left = %v, want %v", got, want)
+	}
+	if got, want := g.Neighbors8(Point{2, 2}), []Point{{1, 1}, {1, 2}, {2, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Neighbors8 of bottom right = %v, want %v", got, want)
+	}
+	if got := len(g.Neighbors8(Point{1, 1})); got != 8 {
+		t.Errorf("Neighbors8 of center has %d points, want 8", got)
+	}
+}
+
+func TestRegionClipping(t *testing.T) {
+	g := parse(t, "abc\ndef\nghi\n")
+
+	var got []byte
+	g.Region(Point{-5, 1}, Point{1, 10}, func(p Point, value byte) {
+		got = append(got, value)
+	})
+	if string(got) != "bcef" {
+		t.Errorf("Region = %q, want \"bcef\"", got)
+	}
+
+	got = nil
+	g.Region(Point{5, 5}, Point{6, 6}, func(p Point, value byte) {
+		got = append(got, value)
+	})
+	if len(got) != 0 {
+		t.Errorf("Region outside the grid = %q, want nothing", got)
+	}
+}
+
+func TestTokensAtRowEnds(t *testing.T) {
+	g := parse(t, "..12\n3...\n..45\n")
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+
+	var got []string
+	for _, token := range g.Tokens(isDigit) {
+		got = append(got, string(token.Cells))
+	}
+	want := []string{"12", "3", "45"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokens = %v, want %v (runs mustn't continue onto the next row)", got, want)
+	}
+
+	tokens := g.Tokens(isDigit)
+	if end := tokens[2].End(); end != (Point{2, 3}) {
+		t.Errorf("End of last token = %v, want {2 3}", end)
+	}
+}
+
+func TestParseNeighborhood(t *testing.T) {
+	for _, s := range []string{"", "5", "chebyshev", "chebyshev:0", "manhattan:-1", "euclid:2", "manhattan:x"} {
+		if _, err := ParseNeighborhood(s); err == nil {
+			t.Errorf("ParseNeighborhood(%q) succeeded, want an error", s)
+		}
+	}
+
+	n, err := ParseNeighborhood("manhattan:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(n.Offsets()); got != 12 {
+		t.Errorf("manhattan:2 has %d offsets, want 12", got)
+	}
+}